@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// BlobStore - stores contents of uploaded files
+type BlobStore interface {
+	// Put - stores a blob under the given id. Returns amount of bytes written
+	Put(id string, r io.Reader) (int64, error)
+	// Get - opens a blob for reading
+	Get(id string) (io.ReadCloser, error)
+	// Delete - deletes a blob
+	Delete(id string) error
+	// List - gets ids of blobs last modified before the given time
+	List(before time.Time) ([]string, error)
+}
+
+// blobIDRegexp - matches valid blob ids
+var blobIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// NewBlobID - generates a new random blob id
+func NewBlobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// LocalBlobStore - a BlobStore which keeps blobs in a directory on the local filesystem
+type LocalBlobStore struct {
+	Root string
+}
+
+// NewLocalBlobStore - creates a new LocalBlobStore, creating its root directory if needed
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	err := os.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{Root: root}, nil
+}
+
+// path - gets path of a blob. Blobs are spread over subdirectories by the first two characters of their ids
+func (s *LocalBlobStore) path(id string) (string, error) {
+	if !blobIDRegexp.MatchString(id) {
+		return "", errors.New("invalid blob id")
+	}
+	return filepath.Join(s.Root, id[0:2], id), nil
+}
+
+// Put - stores a blob under the given id. Returns amount of bytes written
+func (s *LocalBlobStore) Put(id string, r io.Reader) (int64, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return 0, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// Get - opens a blob for reading
+func (s *LocalBlobStore) Get(id string) (io.ReadCloser, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete - deletes a blob
+func (s *LocalBlobStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// List - gets ids of blobs last modified before the given time
+func (s *LocalBlobStore) List(before time.Time) ([]string, error) {
+	ids := []string{}
+	err := filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && blobIDRegexp.MatchString(info.Name()) && info.ModTime().Before(before) {
+			ids = append(ids, info.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CommandArg - describes an argument of a slash command
+type CommandArg struct {
+	Name string `json:"name"`
+	// Type - hints clients what to autocomplete: "user", "channel", "word" or "text". A "text" argument takes the rest of the input
+	Type     string   `json:"type"`
+	Optional bool     `json:"optional"`
+	Choices  []string `json:"choices,omitempty"`
+}
+
+// CommandContext - describes where a slash command was used
+type CommandContext struct {
+	Channel     string
+	ReplyTo     int
+	Attachments []string
+	TTL         int
+}
+
+// Command - describes a slash command which can be used in post-message content
+type Command struct {
+	Name  string        `json:"name"`
+	Usage string        `json:"usage"`
+	Help  string        `json:"help"`
+	Args  []*CommandArg `json:"args"`
+	// Parse - splits the input following the command name into arguments. parseCommandArgs is used if it is nil
+	Parse func(cmd *Command, input string) ([]string, error) `json:"-"`
+	// Run - runs the command with parsed arguments
+	Run func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) `json:"-"`
+}
+
+// commands - all registered slash commands by name
+var commands = map[string]*Command{}
+
+// RegisterCommand - adds a slash command to the registry
+func RegisterCommand(cmd *Command) {
+	if _, ok := commands[cmd.Name]; ok {
+		panic("slash command /" + cmd.Name + " is registered twice")
+	}
+	commands[cmd.Name] = cmd
+}
+
+// ListCommands - gets all registered slash commands sorted by name
+func ListCommands() []*Command {
+	list := []*Command{}
+	for _, cmd := range commands {
+		list = append(list, cmd)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// parseCommandArgs - splits input by whitespace according to the command's arguments
+func parseCommandArgs(cmd *Command, input string) ([]string, error) {
+	args := []string{}
+	rest := strings.TrimSpace(input)
+	for _, arg := range cmd.Args {
+		if rest == "" {
+			if !arg.Optional {
+				return nil, errors.New("missing argument " + arg.Name)
+			}
+			args = append(args, "")
+			continue
+		}
+		if arg.Type == "text" {
+			args = append(args, rest)
+			rest = ""
+			continue
+		}
+		// arguments can be separated by any amount of whitespace, like in strings.Fields
+		value := rest
+		rest = ""
+		if i := strings.IndexFunc(value, unicode.IsSpace); i != -1 {
+			value, rest = value[:i], strings.TrimSpace(value[i:])
+		}
+		if len(arg.Choices) != 0 && !contains(arg.Choices, value) {
+			return nil, errors.New(arg.Name + " must be one of " + strings.Join(arg.Choices, ", "))
+		}
+		args = append(args, value)
+	}
+	if rest != "" {
+		return nil, errors.New("too many arguments")
+	}
+	return args, nil
+}
+
+// runCommand - runs a slash command used in post-message content. content MUST start with a slash
+func runCommand(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, content string, send func(BaseMessage)) {
+	// the name can be followed by a newline or any other whitespace, like arguments
+	name, input := content[1:], ""
+	if i := strings.IndexFunc(name, unicode.IsSpace); i != -1 {
+		name, input = name[:i], name[i:]
+	}
+	name = strings.ToLower(name)
+	cmd, ok := commands[name]
+	if !ok {
+		send(&MessageFail{Message: "unknown command /" + name})
+		return
+	}
+	parse := cmd.Parse
+	if parse == nil {
+		parse = parseCommandArgs
+	}
+	args, err := parse(cmd, input)
+	if err != nil {
+		send(&MessageFail{Message: err.Error() + "; usage: " + cmd.Usage})
+		return
+	}
+	cmd.Run(mel, connInfo, ctx, args, send)
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:  "topic",
+		Usage: "/topic [text]",
+		Help:  "Changes topic of the current channel. Clears it if no text is given.",
+		Args:  []*CommandArg{{Name: "text", Type: "text", Optional: true}},
+		Run: func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) {
+			handleChannelTopicMessage(mel, connInfo, &MessageChannelTopic{Name: ctx.Channel, Topic: args[0]}, send)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "kick",
+		Usage: "/kick <user> [reason]",
+		Help:  "Disconnects a user from the server.",
+		Args:  []*CommandArg{{Name: "user", Type: "user"}, {Name: "reason", Type: "text", Optional: true}},
+		Run: func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) {
+			handleKickMessage(mel, connInfo, &MessageKick{Username: args[0], Reason: args[1]}, send)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "ban",
+		Usage: "/ban <user> [reason]",
+		Help:  "Disconnects a user from the server and bans them permanently.",
+		Args:  []*CommandArg{{Name: "user", Type: "user"}, {Name: "reason", Type: "text", Optional: true}},
+		Run: func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) {
+			handleKickMessage(mel, connInfo, &MessageKick{Username: args[0], Ban: true, Reason: args[1]}, send)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "unban",
+		Usage: "/unban <user>",
+		Help:  "Lifts a ban of a user.",
+		Args:  []*CommandArg{{Name: "user", Type: "user"}},
+		Run: func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) {
+			handleUnbanMessage(mel, connInfo, &MessageUnban{Username: args[0]}, send)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "timeout",
+		Usage: "/timeout <user> <seconds> [reason]",
+		Help:  "Stops a user from posting, reacting and typing in the current channel for some time. 0 seconds lifts the timeout.",
+		Args:  []*CommandArg{{Name: "user", Type: "user"}, {Name: "seconds", Type: "word"}, {Name: "reason", Type: "text", Optional: true}},
+		Run: func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) {
+			duration, err := strconv.Atoi(args[1])
+			if err != nil {
+				send(&MessageFail{Message: "seconds must be a number; usage: /timeout <user> <seconds> [reason]"})
+				return
+			}
+			handleTimeoutMessage(mel, connInfo, &MessageTimeout{Username: args[0], Channel: ctx.Channel, Duration: duration, Reason: args[2]}, send)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "me",
+		Usage: "/me <text>",
+		Help:  "Posts an action message, displayed in italics.",
+		Args:  []*CommandArg{{Name: "text", Type: "text"}},
+		Run: func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) {
+			postMessage(mel, connInfo, &MessagePostMsg{Content: "_" + args[0] + "_", Channel: ctx.Channel, ReplyTo: ctx.ReplyTo, Attachments: ctx.Attachments, TTL: ctx.TTL}, send)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "shrug",
+		Usage: "/shrug [text]",
+		Help:  "Appends ¯\\_(ツ)_/¯ to the message.",
+		Args:  []*CommandArg{{Name: "text", Type: "text", Optional: true}},
+		Run: func(mel *Melodious, connInfo *ConnInfo, ctx *CommandContext, args []string, send func(BaseMessage)) {
+			content := strings.TrimSpace(args[0] + ` ¯\_(ツ)_/¯`)
+			postMessage(mel, connInfo, &MessagePostMsg{Content: content, Channel: ctx.Channel, ReplyTo: ctx.ReplyTo, Attachments: ctx.Attachments, TTL: ctx.TTL}, send)
+		},
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 )
 
@@ -15,17 +16,37 @@ type Config struct {
 	DeleteHistoryEvery string `json:"delete-history-every"`
 	StoreHistoryFor    string `json:"store-history-for"`
 
+	// Attachments are stored in this directory
+	UploadDir string `json:"upload-dir"`
+	// These are sizes in bytes
+	MaxUploadSize      int64 `json:"max-upload-size"`
+	MaxUserUploadsSize int64 `json:"max-user-uploads-size"`
+
+	// Every connection can send rate-limit messages per second on average, and up to rate-limit-burst at once
+	RateLimit      float64 `json:"rate-limit"`
+	RateLimitBurst int     `json:"rate-limit-burst"`
+
 	// Misc data
 	ServerName string `json:"server-name"`
 }
 
 // NewConfig - creates a new Config instance from given JSON data
 func NewConfig(data []byte) (*Config, error) {
-	var cfg Config
+	cfg := Config{
+		UploadDir:          "./uploads",
+		MaxUploadSize:      8 * 1024 * 1024,
+		MaxUserUploadsSize: 256 * 1024 * 1024,
+		RateLimit:          5,
+		RateLimitBurst:     20,
+	}
 	err := json.Unmarshal(data, &cfg)
 	if err != nil {
 		return nil, err
 	}
+	// a bucket which cannot hold a single message would refuse everything
+	if cfg.RateLimit > 0 && cfg.RateLimitBurst < 1 {
+		return nil, errors.New("rate-limit-burst must be at least 1 when rate-limit is set")
+	}
 	return &cfg, nil
 }
 
@@ -33,15 +33,7 @@ func (connInfo *ConnInfo) HasPerm(channel string, flag string) (bool, error) {
 	if !connInfo.loggedIn {
 		return false, nil
 	}
-	owner, err := connInfo.mel.Database.IsUserOwner(connInfo.username)
-	if err != nil {
-		return false, err
-	}
-	if owner {
-		return true, nil
-	}
-	has, err := connInfo.mel.Database.HasFlag(connInfo.username, channel, flag)
-	return has, err
+	return connInfo.mel.HasPerm(connInfo.username, channel, flag)
 }
 
 // HasPermChID - checks if the given connection has the given permission
@@ -101,6 +93,8 @@ func handleConnection(mel *Melodious, conn *websocket.Conn) {
 
 	running := true
 
+	limiter := NewTokenBucket(mel.Config.RateLimit, mel.Config.RateLimitBurst)
+
 	// receiver
 	go func() {
 		for running {
@@ -152,6 +146,14 @@ func handleConnection(mel *Melodious, conn *websocket.Conn) {
 					running = false
 					conn.Close()
 				}
+				if ok, wait := limiter.Take(); !ok {
+					fail := &MessageFail{Message: "you are sending messages too fast", RetryAfter: retryAfter(wait)}
+					if id, ok := msg.GetData().GetID(); ok {
+						fail.GetData().SetID(id)
+					}
+					messageStream <- fail
+					return
+				}
 				go mh(msg)
 			}()
 		}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/apex/log"
@@ -21,7 +23,10 @@ type Database struct {
 // GetUsersList - gets users' data stored in the database
 func (db *Database) GetUsersList() ([]*User, error) {
 	rows, err := db.db.Query(`
-		SELECT id, username, owner FROM melodious.accounts WHERE banned=false;
+		SELECT id, username, owner FROM melodious.accounts a
+		WHERE NOT EXISTS(
+			SELECT 1 FROM melodious.bans b WHERE b.user_id=a.id AND (b.expires IS NULL OR b.expires > NOW())
+		);
 	`)
 	if err != nil {
 		return []*User{}, err
@@ -76,26 +81,52 @@ func (db *Database) RegisterUserOwner(name string, passhash string, owner bool,
 	return nil
 }
 
-// DeleteUser - deletes/unregisters a user
-func (db *Database) DeleteUser(name string) error {
-	_, err := db.db.Exec(`
-		DELETE FROM melodious.accounts WHERE username=$1;
+// DeleteUser - deletes/unregisters a user. Returns blob IDs of files the user uploaded
+func (db *Database) DeleteUser(name string) ([]string, error) {
+	row := db.db.QueryRow(`
+		SELECT id FROM melodious.accounts WHERE username=$1;
 	`, name)
-	if err != nil {
-		return err
+	var id int
+	err := row.Scan(&id)
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	} else if err != nil {
+		return []string{}, err
 	}
-	return nil
+	return db.DeleteUserID(id)
 }
 
-// DeleteUserID - deletes/unregisters a user by their id
-func (db *Database) DeleteUserID(id int) error {
-	_, err := db.db.Exec(`
+// DeleteUserID - deletes/unregisters a user by their id. Returns blob IDs of files the user uploaded
+func (db *Database) DeleteUserID(id int) ([]string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return []string{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		DELETE FROM melodious.attachments WHERE uploader_id=$1 RETURNING id;
+	`, id)
+	if err != nil {
+		return []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return []string{}, err
+	}
+
+	_, err = tx.Exec(`
 		DELETE FROM melodious.accounts WHERE id=$1;
 	`, id)
 	if err != nil {
-		return err
+		return []string{}, err
 	}
-	return nil
+
+	err = tx.Commit()
+	if err != nil {
+		return []string{}, err
+	}
+	return blobs, nil
 }
 
 // GetUserID - gets id of user with the given username.
@@ -254,53 +285,379 @@ func (db *Database) SetUserOwnerID(id int, owner bool) error {
 	return nil
 }
 
-// Ban - sets user's banned flag to true
-func (db *Database) Ban(username string) error {
-	_, err := db.db.Exec(`
-		UPDATE melodious.accounts SET banned=true WHERE username=$1;
+// Ban - bans a user for duration seconds or permanently if duration is 0. Replaces an existing ban of the user
+func (db *Database) Ban(username string, issuer string, reason string, duration int) error {
+	row := db.db.QueryRow(`
+		SELECT id FROM melodious.accounts WHERE username=$1;
 	`, username)
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return err
+	}
+	return db.BanID(id, issuer, reason, duration)
+}
+
+// BanID - bans a user by id for duration seconds or permanently if duration is 0. Replaces an existing ban of the user
+func (db *Database) BanID(id int, issuer string, reason string, duration int) error {
+	// make sure we pass NULL to PostgreSQL for permanent bans
+	var d interface{}
+	if duration != 0 {
+		d = duration
+	} else {
+		d = nil
+	}
+	_, err := db.db.Exec(`
+		INSERT INTO melodious.bans (user_id, issuer_id, reason, dt, expires)
+		VALUES (
+			$1,
+			(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1),
+			$3,
+			NOW(),
+			NOW() + $4::int4 * INTERVAL '1 second'
+		)
+		ON CONFLICT (user_id) DO UPDATE
+		SET issuer_id=EXCLUDED.issuer_id, reason=EXCLUDED.reason, dt=EXCLUDED.dt, expires=EXCLUDED.expires;
+	`, id, issuer, reason, d)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// BanID - sets user's banned flag to true by id
-func (db *Database) BanID(id int) error {
+// Unban - lifts a ban of a user. Returns false if the user is not banned
+func (db *Database) Unban(username string) (bool, error) {
+	res, err := db.db.Exec(`
+		DELETE FROM melodious.bans
+		WHERE user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+			AND (expires IS NULL OR expires > NOW());
+	`, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// GetBan - gets an active ban of a user. Returns sql.ErrNoRows if the user is not banned
+func (db *Database) GetBan(username string) (*Ban, error) {
+	bans, err := db.queryBans(`
+		SELECT a.username, i.username, b.reason, b.dt, b.expires
+		FROM melodious.bans b
+		INNER JOIN melodious.accounts a ON b.user_id = a.id
+		LEFT JOIN melodious.accounts i ON b.issuer_id = i.id
+		WHERE a.username=$1 AND (b.expires IS NULL OR b.expires > NOW());
+	`, username)
+	if err != nil {
+		return nil, err
+	} else if len(bans) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return bans[0], nil
+}
+
+// ListBans - gets all active bans, newest first
+func (db *Database) ListBans() ([]*Ban, error) {
+	return db.queryBans(`
+		SELECT a.username, i.username, b.reason, b.dt, b.expires
+		FROM melodious.bans b
+		INNER JOIN melodious.accounts a ON b.user_id = a.id
+		LEFT JOIN melodious.accounts i ON b.issuer_id = i.id
+		WHERE b.expires IS NULL OR b.expires > NOW()
+		ORDER BY b.dt DESC;
+	`)
+}
+
+// queryBans - runs a query returning (username, issuer, reason, dt, expires) rows of bans
+func (db *Database) queryBans(query string, args ...interface{}) ([]*Ban, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return []*Ban{}, err
+	}
+	defer rows.Close()
+	bans := []*Ban{}
+	for rows.Next() {
+		ban := &Ban{}
+		var issuer sql.NullString
+		var expires sql.NullString
+		err := rows.Scan(&(ban.Username), &issuer, &(ban.Reason), &(ban.Timestamp), &expires)
+		if err != nil {
+			return []*Ban{}, err
+		}
+		ban.Issuer = issuer.String
+		ban.Expires = expires.String
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+// DeleteExpiredBans - deletes user and IP bans and timeouts whose time has passed
+func (db *Database) DeleteExpiredBans() error {
 	_, err := db.db.Exec(`
-		UPDATE melodious.accounts SET banned=true WHERE id=$1;
-	`, id)
+		DELETE FROM melodious.bans WHERE expires <= NOW();
+		DELETE FROM melodious.ip_bans WHERE expires <= NOW();
+		DELETE FROM melodious.timeouts WHERE expires <= NOW();
+	`)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// IsUserBanned - checks if the given user with ip is banned
+// Timeout - stops a user from posting, reacting and typing in a channel, or server-wide if channel is empty, for
+// duration seconds. Replaces an existing timeout of the user in the same place
+func (db *Database) Timeout(username string, channel string, issuer string, reason string, duration int) (*Timeout, error) {
+	// make sure we pass NULL to PostgreSQL for server-wide timeouts
+	var c interface{}
+	if channel != "" {
+		c = channel
+	} else {
+		c = nil
+	}
+	timeouts, err := db.queryTimeouts(`
+		WITH t AS (
+			INSERT INTO melodious.timeouts (user_id, chan_id, issuer_id, reason, dt, expires)
+			VALUES (
+				(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1),
+				(SELECT id FROM melodious.channels WHERE name=$2::varchar LIMIT 1),
+				(SELECT id FROM melodious.accounts WHERE username=$3 LIMIT 1),
+				$4,
+				NOW(),
+				NOW() + $5::int4 * INTERVAL '1 second'
+			)
+			ON CONFLICT (user_id, COALESCE(chan_id, 0)) DO UPDATE
+			SET issuer_id=EXCLUDED.issuer_id, reason=EXCLUDED.reason, dt=EXCLUDED.dt, expires=EXCLUDED.expires
+			RETURNING *
+		)
+		SELECT a.username, c.name, i.username, t.reason, t.dt, t.expires
+		FROM t
+		INNER JOIN melodious.accounts a ON t.user_id = a.id
+		LEFT JOIN melodious.channels c ON t.chan_id = c.id
+		LEFT JOIN melodious.accounts i ON t.issuer_id = i.id;
+	`, username, c, issuer, reason, duration)
+	if err != nil {
+		return nil, err
+	} else if len(timeouts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return timeouts[0], nil
+}
+
+// RemoveTimeout - lifts a timeout of a user in a channel, or a server-wide one if channel is empty. Returns the lifted
+// timeout or sql.ErrNoRows if there is no such active timeout
+func (db *Database) RemoveTimeout(username string, channel string) (*Timeout, error) {
+	// make sure we pass NULL to PostgreSQL for server-wide timeouts
+	var c interface{}
+	if channel != "" {
+		c = channel
+	} else {
+		c = nil
+	}
+	timeouts, err := db.queryTimeouts(`
+		WITH t AS (
+			DELETE FROM melodious.timeouts
+			WHERE user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+				AND chan_id IS NOT DISTINCT FROM (SELECT id FROM melodious.channels WHERE name=$2::varchar LIMIT 1)
+				AND expires > NOW()
+			RETURNING *
+		)
+		SELECT a.username, c.name, i.username, t.reason, t.dt, t.expires
+		FROM t
+		INNER JOIN melodious.accounts a ON t.user_id = a.id
+		LEFT JOIN melodious.channels c ON t.chan_id = c.id
+		LEFT JOIN melodious.accounts i ON t.issuer_id = i.id;
+	`, username, c)
+	if err != nil {
+		return nil, err
+	} else if len(timeouts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return timeouts[0], nil
+}
+
+// GetTimeout - gets the longest active timeout of a user applying to a channel, either server-wide or in the channel.
+// Returns sql.ErrNoRows if the user is not timed out there
+func (db *Database) GetTimeout(username string, channel string) (*Timeout, error) {
+	timeouts, err := db.queryTimeouts(`
+		SELECT a.username, c.name, i.username, t.reason, t.dt, t.expires
+		FROM melodious.timeouts t
+		INNER JOIN melodious.accounts a ON t.user_id = a.id
+		LEFT JOIN melodious.channels c ON t.chan_id = c.id
+		LEFT JOIN melodious.accounts i ON t.issuer_id = i.id
+		WHERE a.username=$1 AND (t.chan_id IS NULL OR c.name=$2) AND t.expires > NOW()
+		ORDER BY t.expires DESC
+		LIMIT 1;
+	`, username, channel)
+	if err != nil {
+		return nil, err
+	} else if len(timeouts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return timeouts[0], nil
+}
+
+// queryTimeouts - runs a query returning (username, channel, issuer, reason, dt, expires) rows of timeouts
+func (db *Database) queryTimeouts(query string, args ...interface{}) ([]*Timeout, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return []*Timeout{}, err
+	}
+	defer rows.Close()
+	timeouts := []*Timeout{}
+	for rows.Next() {
+		timeout := &Timeout{}
+		var channel sql.NullString
+		var issuer sql.NullString
+		err := rows.Scan(&(timeout.Username), &channel, &issuer, &(timeout.Reason), &(timeout.Timestamp), &(timeout.Expires))
+		if err != nil {
+			return []*Timeout{}, err
+		}
+		timeout.Channel = channel.String
+		timeout.Issuer = issuer.String
+		timeouts = append(timeouts, timeout)
+	}
+	return timeouts, nil
+}
+
+// AddIPBan - bans an IP address range for duration seconds or permanently if duration is 0. Returns the ban's id
+func (db *Database) AddIPBan(ipRange string, issuer string, reason string, duration int) (int, error) {
+	// make sure we pass NULL to PostgreSQL for permanent bans
+	var d interface{}
+	if duration != 0 {
+		d = duration
+	} else {
+		d = nil
+	}
+	row := db.db.QueryRow(`
+		INSERT INTO melodious.ip_bans (ip_range, issuer_id, reason, dt, expires)
+		VALUES (
+			$1::cidr,
+			(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1),
+			$3,
+			NOW(),
+			NOW() + $4::int4 * INTERVAL '1 second'
+		)
+		RETURNING id;
+	`, ipRange, issuer, reason, d)
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// DeleteIPBan - lifts an IP ban by id. Returns the lifted ban or sql.ErrNoRows if there is no such active ban
+func (db *Database) DeleteIPBan(id int) (*IPBan, error) {
+	bans, err := db.queryIPBans(`
+		WITH deleted AS (
+			DELETE FROM melodious.ip_bans WHERE id=$1 AND (expires IS NULL OR expires > NOW())
+			RETURNING *
+		)
+		SELECT b.id, b.ip_range, i.username, b.reason, b.dt, b.expires
+		FROM deleted b
+		LEFT JOIN melodious.accounts i ON b.issuer_id = i.id;
+	`, id)
+	if err != nil {
+		return nil, err
+	} else if len(bans) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return bans[0], nil
+}
+
+// ListIPBans - gets all active IP bans, newest first
+func (db *Database) ListIPBans() ([]*IPBan, error) {
+	return db.queryIPBans(`
+		SELECT b.id, b.ip_range, i.username, b.reason, b.dt, b.expires
+		FROM melodious.ip_bans b
+		LEFT JOIN melodious.accounts i ON b.issuer_id = i.id
+		WHERE b.expires IS NULL OR b.expires > NOW()
+		ORDER BY b.id DESC;
+	`)
+}
+
+// queryIPBans - runs a query returning (id, range, issuer, reason, dt, expires) rows of IP bans
+func (db *Database) queryIPBans(query string, args ...interface{}) ([]*IPBan, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return []*IPBan{}, err
+	}
+	defer rows.Close()
+	bans := []*IPBan{}
+	for rows.Next() {
+		ban := &IPBan{}
+		var issuer sql.NullString
+		var expires sql.NullString
+		err := rows.Scan(&(ban.ID), &(ban.Range), &issuer, &(ban.Reason), &(ban.Timestamp), &expires)
+		if err != nil {
+			return []*IPBan{}, err
+		}
+		ban.Issuer = issuer.String
+		ban.Expires = expires.String
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+// IsIPBanned - checks if an IP address is in a banned range
+func (db *Database) IsIPBanned(ip string) (bool, error) {
+	row := db.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM melodious.ip_bans
+			WHERE $1::inet <<= ip_range AND (expires IS NULL OR expires > NOW())
+		);
+	`, ip)
+	var banned bool
+	err := row.Scan(&banned)
+	if err != nil {
+		return false, err
+	}
+	return banned, nil
+}
+
+// IsUserBanned - checks if the given user or any user with the given ip is banned, or if the user was registered from a
+// banned range. Banned ranges the ip is in are checked by IsIPBanned
 func (db *Database) IsUserBanned(username string, ip string) (bool, error) {
 	row := db.db.QueryRow(`
-		SELECT banned FROM melodious.accounts WHERE username=$1 OR ip=$2;
+		SELECT EXISTS(
+			SELECT 1 FROM melodious.bans b
+			INNER JOIN melodious.accounts a ON b.user_id = a.id
+			WHERE (a.username=$1 OR a.ip=$2) AND (b.expires IS NULL OR b.expires > NOW())
+		) OR EXISTS(
+			SELECT 1 FROM melodious.accounts a
+			INNER JOIN melodious.ip_bans ib ON a.ip <<= ib.ip_range
+			WHERE a.username=$1 AND (ib.expires IS NULL OR ib.expires > NOW())
+		);
 	`, username, ip)
 	var banned bool
 	err := row.Scan(&banned)
-	if err == sql.ErrNoRows {
-		return false, nil
-	} else if err != nil {
+	if err != nil {
 		return false, err
 	}
 	return banned, nil
 }
 
-// IsUserBannedID - checks if the given user by id with ip is banned
+// IsUserBannedID - checks if the given user by id or any user with the given ip is banned, or if the user was registered
+// from a banned range. Banned ranges the ip is in are checked by IsIPBanned
 func (db *Database) IsUserBannedID(id int, ip string) (bool, error) {
 	row := db.db.QueryRow(`
-		SELECT banned FROM melodious.accounts WHERE id=$1 OR ip=$2;
+		SELECT EXISTS(
+			SELECT 1 FROM melodious.bans b
+			INNER JOIN melodious.accounts a ON b.user_id = a.id
+			WHERE (a.id=$1 OR a.ip=$2) AND (b.expires IS NULL OR b.expires > NOW())
+		) OR EXISTS(
+			SELECT 1 FROM melodious.accounts a
+			INNER JOIN melodious.ip_bans ib ON a.ip <<= ib.ip_range
+			WHERE a.id=$1 AND (ib.expires IS NULL OR ib.expires > NOW())
+		);
 	`, id, ip)
 	var banned bool
 	err := row.Scan(&banned)
-	if err == sql.ErrNoRows {
-		return false, nil
-	} else if err != nil {
+	if err != nil {
 		return false, err
 	}
 	return banned, nil
@@ -330,32 +687,58 @@ func (db *Database) NewChannel(name string, topic string) error {
 	return nil
 }
 
-// DeleteChannel - deletes given channel
-func (db *Database) DeleteChannel(name string) error {
-	_, err := db.db.Exec(`
-		DELETE FROM melodious.channels WHERE name=$1;
+// DeleteChannel - deletes given channel. Returns blob IDs of files uploaded to it
+func (db *Database) DeleteChannel(name string) ([]string, error) {
+	row := db.db.QueryRow(`
+		SELECT id FROM melodious.channels WHERE name=$1;
 	`, name)
-	if err != nil {
-		return err
+	var id int
+	err := row.Scan(&id)
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	} else if err != nil {
+		return []string{}, err
 	}
-	return nil
+	return db.DeleteChannelID(id)
 }
 
-// DeleteChannelID - deletes given channel by ID
-func (db *Database) DeleteChannelID(id int) error {
-	_, err := db.db.Exec(`
+// DeleteChannelID - deletes given channel by ID. Returns blob IDs of files uploaded to it
+func (db *Database) DeleteChannelID(id int) ([]string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return []string{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		DELETE FROM melodious.attachments WHERE chan_id=$1 RETURNING id;
+	`, id)
+	if err != nil {
+		return []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return []string{}, err
+	}
+
+	_, err = tx.Exec(`
 		DELETE FROM melodious.channels WHERE id=$1;
 	`, id)
 	if err != nil {
-		return err
+		return []string{}, err
 	}
-	return nil
+
+	err = tx.Commit()
+	if err != nil {
+		return []string{}, err
+	}
+	return blobs, nil
 }
 
 // ListChannels - puts all channel names into an array of Channel structs
 func (db *Database) ListChannels() ([]*Channel, error) {
 	rows, err := db.db.Query(`
-		SELECT id, name, topic FROM melodious.channels;
+		SELECT id, name, topic, COALESCE(retention, 0), slowmode FROM melodious.channels;
 	`)
 	if err != nil {
 		return nil, err
@@ -365,7 +748,7 @@ func (db *Database) ListChannels() ([]*Channel, error) {
 
 	for rows.Next() {
 		chnl := &Channel{}
-		if err := rows.Scan(&(chnl.ID), &(chnl.Name), &(chnl.Topic)); err != nil {
+		if err := rows.Scan(&(chnl.ID), &(chnl.Name), &(chnl.Topic), &(chnl.Retention), &(chnl.Slowmode)); err != nil {
 			return nil, err
 		}
 		m = append(m, chnl)
@@ -374,6 +757,45 @@ func (db *Database) ListChannels() ([]*Channel, error) {
 	return m, nil
 }
 
+// GetReadableChannelIDs - gets ids of channels whose messages a user can get. If channel is not empty, only that
+// channel is considered
+func (db *Database) GetReadableChannelIDs(username string, channel string) ([]int, error) {
+	rows, err := db.db.Query(`
+		SELECT c.id FROM melodious.channels c
+		WHERE ($2::varchar = '' OR c.name = $2::varchar) AND (
+			EXISTS(SELECT 1 FROM melodious.accounts WHERE username=$1 AND owner) OR
+			EXISTS(SELECT 1 FROM melodious.query_flags($1, c.name, '', 'perms.get-messages', true))
+		);
+	`, username, channel)
+	if err != nil {
+		return []int{}, err
+	}
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return []int{}, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetChannel - gets a channel by name
+func (db *Database) GetChannel(name string) (*Channel, error) {
+	row := db.db.QueryRow(`
+		SELECT id, name, topic, COALESCE(retention, 0), slowmode FROM melodious.channels WHERE name=$1;
+	`, name)
+	chnl := &Channel{}
+	err := row.Scan(&(chnl.ID), &(chnl.Name), &(chnl.Topic), &(chnl.Retention), &(chnl.Slowmode))
+	if err != nil {
+		return nil, err
+	}
+	return chnl, nil
+}
+
 // ChannelExists - checks if a channel exists
 func (db *Database) ChannelExists(name string) (bool, error) {
 	row := db.db.QueryRow(`
@@ -398,134 +820,2297 @@ func (db *Database) SetChannelTopic(name string, topic string) error {
 	return nil
 }
 
-// DeleteOldMessages - deletes old messages from the database
-func (db *Database) DeleteOldMessages(period string) error {
-	_, err := db.db.Exec(`
-		DELETE FROM melodious.messages WHERE dt < (NOW() - $1::INTERVAL);
+// DeleteOldMessages - deletes messages older than the retention period of their channel or the given period
+// if the channel does not have one. Returns IDs of deleted messages by channel name and blob IDs of their attachments
+func (db *Database) DeleteOldMessages(period string) (map[string][]int, []string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return map[string][]int{}, []string{}, err
+	}
+	defer tx.Rollback()
+
+	// NOW() is the same for the whole transaction, so both statements pick the same messages
+	rows, err := tx.Query(`
+		DELETE FROM melodious.attachments at
+		USING melodious.messages m, melodious.channels c
+		WHERE at.message_id = m.id AND m.chan_id = c.id
+			AND m.dt < NOW() - COALESCE(c.retention * INTERVAL '1 second', $1::INTERVAL)
+		RETURNING at.id;
 	`, period)
 	if err != nil {
-		return err
+		return map[string][]int{}, []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return map[string][]int{}, []string{}, err
 	}
-	return nil
-}
 
-// PostMessage - posts a new message
-func (db *Database) PostMessage(chanName string, message string, pings []string, author string) (*ChatMessage, error) {
-	row := db.db.QueryRow(`
-		INSERT INTO melodious.messages
-		(chan_id, message, dt, pings, author_id)
-		VALUES (
-			(SELECT id FROM melodious.channels WHERE name=$1 LIMIT 1),
-			$2,
-			NOW(),
-			$3,
-			(SELECT id FROM melodious.accounts WHERE username=$4 LIMIT 1)
-		)
-		RETURNING message, pings, id, dt, $4, author_id;
-	`, chanName, message, pq.Array(pings), author)
-	msg := &ChatMessage{}
-	var cpings pq.StringArray
-	err := row.Scan(&(msg.Message), &cpings, &(msg.ID), &(msg.Timestamp), &(msg.Author), &(msg.AuthorID))
+	rows, err = tx.Query(`
+		DELETE FROM melodious.messages m
+		USING melodious.channels c
+		WHERE m.chan_id = c.id AND m.dt < NOW() - COALESCE(c.retention * INTERVAL '1 second', $1::INTERVAL)
+		RETURNING m.id, c.name;
+	`, period)
 	if err != nil {
-		return nil, err
+		return map[string][]int{}, []string{}, err
+	}
+	deleted, err := scanDeletedMessages(rows)
+	if err != nil {
+		return map[string][]int{}, []string{}, err
 	}
-	msg.Pings = []string(cpings)
-	return msg, nil
-}
 
-// PostMessageChanID - posts a new message
-func (db *Database) PostMessageChanID(chanID int, message string, pings []string, author string) error {
-	_, err := db.db.Exec(`
-		INSERT INTO melodious.messages
-		(chan_id, message, dt, pings, author_id)
-		VALUES (
-			(SELECT id FROM melodious.channels WHERE id=$1 LIMIT 1),
-			$2,
-			NOW(),
-			$3,
-			(SELECT id FROM melodious.accounts WHERE username=$4 LIMIT 1)
-		);
-	`, chanID, message, pq.Array(pings), author)
+	err = tx.Commit()
 	if err != nil {
-		return err
+		return map[string][]int{}, []string{}, err
 	}
-	return nil
+	return deleted, blobs, nil
 }
 
-// DeleteMessage - deletes a message by ID.
-func (db *Database) DeleteMessage(id int) error {
-	_, err := db.db.Exec(`
-		DELETE FROM melodious.messages WHERE id=$1;
-	`, id)
+// DeleteExpiredMessages - deletes messages whose TTL has passed. Returns IDs of deleted messages by channel name and
+// blob IDs of their attachments
+func (db *Database) DeleteExpiredMessages() (map[string][]int, []string, error) {
+	tx, err := db.db.Begin()
 	if err != nil {
-		return err
+		return map[string][]int{}, []string{}, err
 	}
-	return nil
-}
+	defer tx.Rollback()
 
-// EditMessage - edits a message by ID.
-//func (db *Database) EditMessage(id int, content string) {
-//todo
-//}
+	rows, err := tx.Query(`
+		DELETE FROM melodious.attachments at
+		USING melodious.messages m
+		WHERE at.message_id = m.id AND m.expires_at <= NOW()
+		RETURNING at.id;
+	`)
+	if err != nil {
+		return map[string][]int{}, []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return map[string][]int{}, []string{}, err
+	}
 
-// GetMessages - gets last n messages in a channel starting from an id from the database
-func (db *Database) GetMessages(chanid int, msgid int, amount int) ([]*ChatMessage, error) {
-	rows, err := db.db.Query(`
-		SELECT
-			m.id,
-			m.message,
+	rows, err = tx.Query(`
+		DELETE FROM melodious.messages m
+		USING melodious.channels c
+		WHERE m.chan_id = c.id AND m.expires_at <= NOW()
+		RETURNING m.id, c.name;
+	`)
+	if err != nil {
+		return map[string][]int{}, []string{}, err
+	}
+	deleted, err := scanDeletedMessages(rows)
+	if err != nil {
+		return map[string][]int{}, []string{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return map[string][]int{}, []string{}, err
+	}
+	return deleted, blobs, nil
+}
+
+// scanBlobIDs - reads ids of deleted attachments. Their files have to be deleted after the deletion is committed
+func scanBlobIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	blobs := []string{}
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return []string{}, err
+		}
+		blobs = append(blobs, id)
+	}
+	return blobs, nil
+}
+
+// scanDeletedMessages - reads (id, channel name) rows of deleted messages
+func scanDeletedMessages(rows *sql.Rows) (map[string][]int, error) {
+	defer rows.Close()
+	deleted := map[string][]int{}
+	for rows.Next() {
+		var id int
+		var channel string
+		err := rows.Scan(&id, &channel)
+		if err != nil {
+			return map[string][]int{}, err
+		}
+		deleted[channel] = append(deleted[channel], id)
+	}
+	return deleted, nil
+}
+
+// SetChannelSlowmode - sets the minimum interval between posts of a user in a channel in seconds. 0 disables slowmode
+func (db *Database) SetChannelSlowmode(name string, slowmode int) error {
+	_, err := db.db.Exec(`
+		UPDATE melodious.channels SET slowmode=$2 WHERE name=$1;
+	`, name, slowmode)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetChannelRetention - sets for how many seconds messages are stored in a channel. 0 means the server-wide period
+func (db *Database) SetChannelRetention(name string, retention int) error {
+	var r interface{}
+	if retention != 0 {
+		r = retention
+	} else {
+		r = nil
+	}
+	_, err := db.db.Exec(`
+		UPDATE melodious.channels SET retention=$2 WHERE name=$1;
+	`, name, r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ErrInvalidAttachments - returned when files to attach to a posted message are not uploaded by its author to its
+// channel or are attached to another message already
+var ErrInvalidAttachments = errors.New("invalid attachments")
+
+// PostMessage - posts a new message. massPing is "everyone", "here" or empty. replyTo is the ID of the thread's root
+// message or 0. Given uploaded files are attached to the message in the same transaction
+func (db *Database) PostMessage(chanName string, message string, pings []string, massPing string, author string, replyTo int, ttl int, attachments []string) (*ChatMessage, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	msg, err := insertMessage(tx, chanName, message, pings, massPing, author, replyTo, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) != 0 {
+		res, err := tx.Exec(`
+			UPDATE melodious.attachments SET message_id=$2
+			WHERE id = ANY($1)
+				AND uploader_id=(SELECT id FROM melodious.accounts WHERE username=$3 LIMIT 1)
+				AND chan_id=(SELECT id FROM melodious.channels WHERE name=$4 LIMIT 1)
+				AND message_id IS NULL;
+		`, pq.Array(attachments), msg.ID, author, chanName)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		} else if n != int64(len(attachments)) {
+			return nil, ErrInvalidAttachments
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// insertMessage - inserts a new message in a transaction. See PostMessage
+func insertMessage(tx *sql.Tx, chanName string, message string, pings []string, massPing string, author string, replyTo int, ttl int) (*ChatMessage, error) {
+	// make sure we pass NULL to PostgreSQL if it's not a reply
+	var reply interface{}
+	if replyTo != 0 {
+		reply = replyTo
+	} else {
+		reply = nil
+	}
+	// same for messages which do not expire
+	var expires interface{}
+	if ttl != 0 {
+		expires = ttl
+	} else {
+		expires = nil
+	}
+	// and for messages without mass mentions
+	var mass interface{}
+	if massPing != "" {
+		mass = massPing
+	} else {
+		mass = nil
+	}
+	row := tx.QueryRow(`
+		INSERT INTO melodious.messages
+		(chan_id, message, dt, pings, mass_ping, author_id, reply_to, expires_at)
+		VALUES (
+			(SELECT id FROM melodious.channels WHERE name=$1 LIMIT 1),
+			$2,
+			NOW(),
+			$3,
+			$7,
+			(SELECT id FROM melodious.accounts WHERE username=$4 LIMIT 1),
+			$5,
+			NOW() + $6::int4 * INTERVAL '1 second'
+		)
+		RETURNING message, pings, id, dt, $4, author_id, expires_at;
+	`, chanName, message, pq.Array(pings), author, reply, expires, mass)
+	msg := &ChatMessage{}
+	var cpings pq.StringArray
+	var cexpires sql.NullString
+	err := row.Scan(&(msg.Message), &cpings, &(msg.ID), &(msg.Timestamp), &(msg.Author), &(msg.AuthorID), &cexpires)
+	if err != nil {
+		return nil, err
+	}
+	msg.Pings = []string(cpings)
+	msg.MassPing = massPing
+	msg.ExpiresAt = cexpires.String
+	msg.ReplyTo = replyTo
+	return msg, nil
+}
+
+// PostMessageChanID - posts a new message
+func (db *Database) PostMessageChanID(chanID int, message string, pings []string, author string) error {
+	_, err := db.db.Exec(`
+		INSERT INTO melodious.messages
+		(chan_id, message, dt, pings, author_id)
+		VALUES (
+			(SELECT id FROM melodious.channels WHERE id=$1 LIMIT 1),
+			$2,
+			NOW(),
+			$3,
+			(SELECT id FROM melodious.accounts WHERE username=$4 LIMIT 1)
+		);
+	`, chanID, message, pq.Array(pings), author)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteMessage - deletes a message by ID. Returns blob IDs of its attachments
+func (db *Database) DeleteMessage(id int) ([]string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return []string{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		DELETE FROM melodious.attachments WHERE message_id=$1 RETURNING id;
+	`, id)
+	if err != nil {
+		return []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return []string{}, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM melodious.messages WHERE id=$1;
+	`, id)
+	if err != nil {
+		return []string{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return []string{}, err
+	}
+	return blobs, nil
+}
+
+// ModDeleteMessage - deletes a message by ID, recording who deleted it and why. Returns blob IDs of its attachments
+func (db *Database) ModDeleteMessage(id int, deleter string, reason string) ([]string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return []string{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO melodious.message_deletions
+		(message_id, chan_id, author_id, deleter_id, message, reason, dt)
+		SELECT
+			id,
+			chan_id,
+			author_id,
+			(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1),
+			message,
+			$3,
+			NOW()
+		FROM melodious.messages WHERE id=$1;
+	`, id, deleter, reason)
+	if err != nil {
+		return []string{}, err
+	}
+
+	rows, err := tx.Query(`
+		DELETE FROM melodious.attachments WHERE message_id=$1 RETURNING id;
+	`, id)
+	if err != nil {
+		return []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return []string{}, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM melodious.messages WHERE id=$1;
+	`, id)
+	if err != nil {
+		return []string{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return []string{}, err
+	}
+	return blobs, nil
+}
+
+// PurgeMessages - deletes up to limit most recent messages of a channel matching the filter in one transaction,
+// recording who deleted them and why. Returns IDs of deleted messages and blob IDs of their attachments
+func (db *Database) PurgeMessages(channel string, filter *PurgeFilter, limit int, deleter string, reason string) ([]int, []string, error) {
+	// make sure we pass NULL to PostgreSQL for unset filters
+	var author, after, before, last interface{}
+	if filter.Author != "" {
+		author = filter.Author
+	}
+	if filter.After != "" {
+		after = filter.After
+	}
+	if filter.Before != "" {
+		before = filter.Before
+	}
+	if filter.Last != 0 {
+		last = filter.Last
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return []int{}, []string{}, err
+	}
+	defer tx.Rollback()
+
+	// only candidates are locked; the "last" window is bounded by the lowest id among the most recent messages. Pattern
+	// is matched here, so candidates are fetched in pages until enough of them match or none are left
+	ids := []int64{}
+	cursor := int64(math.MaxInt64)
+	for len(ids) < limit {
+		rows, err := tx.Query(`
+			SELECT m.id, m.message FROM melodious.messages m
+			WHERE
+				m.chan_id=(SELECT id FROM melodious.channels WHERE name=$1 LIMIT 1) AND
+				($2::varchar IS NULL OR m.author_id=(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1)) AND
+				($3::timestamptz IS NULL OR m.dt >= $3::timestamptz) AND
+				($4::timestamptz IS NULL OR m.dt < $4::timestamptz) AND
+				($5::int4 IS NULL OR m.id >= (
+					SELECT MIN(l.id) FROM (
+						SELECT id FROM melodious.messages
+						WHERE chan_id=(SELECT id FROM melodious.channels WHERE name=$1 LIMIT 1)
+						ORDER BY id DESC
+						LIMIT $5::int4
+					) l
+				)) AND
+				m.id < $7::int8
+			ORDER BY m.id DESC
+			LIMIT $6::int4
+			FOR UPDATE;
+		`, channel, author, after, before, last, limit, cursor)
+		if err != nil {
+			return []int{}, []string{}, err
+		}
+		candidates := 0
+		for rows.Next() {
+			var id int64
+			var message string
+			err := rows.Scan(&id, &message)
+			if err != nil {
+				rows.Close()
+				return []int{}, []string{}, err
+			}
+			candidates++
+			cursor = id
+			if len(ids) < limit && (filter.Pattern == nil || filter.Pattern.MatchString(message)) {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		if candidates < limit {
+			break
+		}
+	}
+	if len(ids) == 0 {
+		return []int{}, []string{}, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO melodious.message_deletions
+		(message_id, chan_id, author_id, deleter_id, message, reason, dt)
+		SELECT
+			id,
+			chan_id,
+			author_id,
+			(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1),
+			message,
+			$3,
+			NOW()
+		FROM melodious.messages WHERE id = ANY($1);
+	`, pq.Array(ids), deleter, reason)
+	if err != nil {
+		return []int{}, []string{}, err
+	}
+
+	rows, err := tx.Query(`
+		DELETE FROM melodious.attachments WHERE message_id = ANY($1) RETURNING id;
+	`, pq.Array(ids))
+	if err != nil {
+		return []int{}, []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return []int{}, []string{}, err
+	}
+
+	rows, err = tx.Query(`
+		DELETE FROM melodious.messages WHERE id = ANY($1) RETURNING id;
+	`, pq.Array(ids))
+	if err != nil {
+		return []int{}, []string{}, err
+	}
+	deleted := []int{}
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return []int{}, []string{}, err
+		}
+		deleted = append(deleted, id)
+	}
+	rows.Close()
+
+	err = tx.Commit()
+	if err != nil {
+		return []int{}, []string{}, err
+	}
+	return deleted, blobs, nil
+}
+
+// EditMessage - edits a message by ID, storing its previous content as a revision. massPing is "everyone", "here" or empty
+func (db *Database) EditMessage(id int, message string, pings []string, massPing string, editor string) (*ChatMessage, error) {
+	// make sure we pass NULL to PostgreSQL for messages without mass mentions
+	var mass interface{}
+	if massPing != "" {
+		mass = massPing
+	} else {
+		mass = nil
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO melodious.message_edits
+		(message_id, editor_id, message, dt)
+		SELECT
+			id,
+			(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1),
+			message,
+			NOW()
+		FROM melodious.messages WHERE id=$1;
+	`, id, editor)
+	if err != nil {
+		return nil, err
+	}
+
+	row := tx.QueryRow(`
+		UPDATE melodious.messages m
+		SET message=$2, pings=$3, mass_ping=$4, edited=NOW()
+		FROM melodious.accounts a
+		WHERE m.id=$1 AND a.id=m.author_id
+		RETURNING m.message, m.pings, m.id, m.dt, a.username, m.author_id, m.edited;
+	`, id, message, pq.Array(pings), mass)
+	msg := &ChatMessage{}
+	var cpings pq.StringArray
+	err = row.Scan(&(msg.Message), &cpings, &(msg.ID), &(msg.Timestamp), &(msg.Author), &(msg.AuthorID), &(msg.Edited))
+	if err != nil {
+		return nil, err
+	}
+	msg.Pings = []string(cpings)
+	msg.MassPing = massPing
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GetMessageEdits - gets previous revisions of a message, oldest first
+func (db *Database) GetMessageEdits(id int) ([]*MessageEdit, error) {
+	rows, err := db.db.Query(`
+		SELECT
+			e.id,
+			e.message_id,
+			e.message,
+			a.username editor,
+			e.dt
+		FROM melodious.message_edits e
+		INNER JOIN melodious.accounts a ON e.editor_id = a.id
+		WHERE e.message_id=$1
+		ORDER BY e.id ASC;
+	`, id)
+	if err != nil {
+		return []*MessageEdit{}, err
+	}
+	defer rows.Close()
+	edits := []*MessageEdit{}
+	for rows.Next() {
+		edit := &MessageEdit{}
+		err := rows.Scan(&(edit.ID), &(edit.MessageID), &(edit.Message), &(edit.Editor), &(edit.Timestamp))
+		if err != nil {
+			return []*MessageEdit{}, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, nil
+}
+
+// GetMessages - gets last n messages in a channel starting from an id from the database.
+// Thread replies are not included, see GetThread
+func (db *Database) GetMessages(chanid int, msgid int, amount int) ([]*ChatMessage, error) {
+	rows, err := db.db.Query(`
+		SELECT
+			m.id,
+			m.message,
 			m.dt,
 			m.pings,
+			m.mass_ping,
 			a.username author,
-			m.author_id
+			m.author_id,
+			m.edited,
+			m.expires_at,
+			(SELECT COUNT(*) FROM melodious.messages r WHERE r.reply_to = m.id) reply_count
 		FROM melodious.messages m
 		INNER JOIN melodious.accounts a ON m.author_id = a.id
-		WHERE m.chan_id=$1 AND m.id<$2
+		WHERE m.chan_id=$1 AND m.id<$2 AND m.reply_to IS NULL
 		ORDER BY m.id DESC
 		LIMIT $3;
 	`, chanid, msgid, amount)
 	if err != nil {
 		return []*ChatMessage{}, err
 	}
+	defer rows.Close()
 	msgs := []*ChatMessage{}
 	for rows.Next() {
-		msg := &ChatMessage{}
+		msg := &ChatMessage{}
+		var pings pq.StringArray
+		var massPing sql.NullString
+		var edited sql.NullString
+		var expires sql.NullString
+		err := rows.Scan(&(msg.ID), &(msg.Message), &(msg.Timestamp), &pings, &massPing, &(msg.Author), &(msg.AuthorID), &edited, &expires, &(msg.ReplyCount))
+		if err != nil {
+			return []*ChatMessage{}, err
+		}
+		msg.Pings = []string(pings)
+		msg.MassPing = massPing.String
+		msg.Edited = edited.String
+		msg.ExpiresAt = expires.String
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// GetMessagesAfter - gets first n messages in a channel after an id from the database, newest first.
+// Thread replies are not included, see GetThread
+func (db *Database) GetMessagesAfter(chanid int, msgid int, amount int) ([]*ChatMessage, error) {
+	rows, err := db.db.Query(`
+		SELECT
+			m.id,
+			m.message,
+			m.dt,
+			m.pings,
+			m.mass_ping,
+			a.username author,
+			m.author_id,
+			m.edited,
+			m.expires_at,
+			(SELECT COUNT(*) FROM melodious.messages r WHERE r.reply_to = m.id) reply_count
+		FROM melodious.messages m
+		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		WHERE m.chan_id=$1 AND m.id>$2 AND m.reply_to IS NULL
+		ORDER BY m.id ASC
+		LIMIT $3;
+	`, chanid, msgid, amount)
+	if err != nil {
+		return []*ChatMessage{}, err
+	}
+	defer rows.Close()
+	msgs := []*ChatMessage{}
+	for rows.Next() {
+		msg := &ChatMessage{}
+		var pings pq.StringArray
+		var massPing sql.NullString
+		var edited sql.NullString
+		var expires sql.NullString
+		err := rows.Scan(&(msg.ID), &(msg.Message), &(msg.Timestamp), &pings, &massPing, &(msg.Author), &(msg.AuthorID), &edited, &expires, &(msg.ReplyCount))
+		if err != nil {
+			return []*ChatMessage{}, err
+		}
+		msg.Pings = []string(pings)
+		msg.MassPing = massPing.String
+		msg.Edited = edited.String
+		msg.ExpiresAt = expires.String
+		msgs = append([]*ChatMessage{msg}, msgs...)
+	}
+	return msgs, nil
+}
+
+// HasMoreMessages - checks if a channel has messages older than the oldest id and newer than the newest id.
+// Thread replies are not counted
+func (db *Database) HasMoreMessages(chanid int, oldest int, newest int) (bool, bool, error) {
+	row := db.db.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM melodious.messages WHERE chan_id=$1 AND reply_to IS NULL AND id<$2),
+			EXISTS(SELECT 1 FROM melodious.messages WHERE chan_id=$1 AND reply_to IS NULL AND id>$3);
+	`, chanid, oldest, newest)
+	var before, after bool
+	err := row.Scan(&before, &after)
+	if err != nil {
+		return false, false, err
+	}
+	return before, after, nil
+}
+
+// GetThread - gets n replies to a message starting after an id, oldest first
+func (db *Database) GetThread(rootid int, msgid int, amount int) ([]*ChatMessage, error) {
+	rows, err := db.db.Query(`
+		SELECT
+			m.id,
+			m.message,
+			m.dt,
+			m.pings,
+			m.mass_ping,
+			a.username author,
+			m.author_id,
+			m.edited,
+			m.expires_at,
+			m.reply_to
+		FROM melodious.messages m
+		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		WHERE m.reply_to=$1 AND m.id>$2
+		ORDER BY m.id ASC
+		LIMIT $3;
+	`, rootid, msgid, amount)
+	if err != nil {
+		return []*ChatMessage{}, err
+	}
+	defer rows.Close()
+	msgs := []*ChatMessage{}
+	for rows.Next() {
+		msg := &ChatMessage{}
+		var pings pq.StringArray
+		var massPing sql.NullString
+		var edited sql.NullString
+		var expires sql.NullString
+		err := rows.Scan(&(msg.ID), &(msg.Message), &(msg.Timestamp), &pings, &massPing, &(msg.Author), &(msg.AuthorID), &edited, &expires, &(msg.ReplyTo))
+		if err != nil {
+			return []*ChatMessage{}, err
+		}
+		msg.Pings = []string(pings)
+		msg.MassPing = massPing.String
+		msg.Edited = edited.String
+		msg.ExpiresAt = expires.String
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// SearchMessages - finds last n messages matching the filter starting from an id.
+// Only channels listed in the filter are searched
+func (db *Database) SearchMessages(filter *SearchFilter, msgid int, amount int) ([]*SearchResult, error) {
+	// make sure empty filters are passed as NULL to PostgreSQL
+	nullable := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	chanids := []int64{}
+	for _, id := range filter.ChannelIDs {
+		chanids = append(chanids, int64(id))
+	}
+	if msgid <= 0 {
+		msgid = math.MaxInt32
+	}
+	rows, err := db.db.Query(`
+		SELECT
+			c.name channel,
+			m.id,
+			m.message,
+			m.dt,
+			m.pings,
+			m.mass_ping,
+			a.username author,
+			m.author_id,
+			m.edited,
+			m.expires_at,
+			m.reply_to
+		FROM melodious.messages m
+		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		INNER JOIN melodious.channels c ON m.chan_id = c.id
+		WHERE m.chan_id = ANY($1) AND m.id<$2
+			AND ($3::TEXT IS NULL OR to_tsvector('simple', m.message) @@ websearch_to_tsquery('simple', $3::TEXT))
+			AND ($4::TEXT IS NULL OR a.username = $4::TEXT)
+			AND ($5::TIMESTAMPTZ IS NULL OR m.dt >= $5::TIMESTAMPTZ)
+			AND ($6::TIMESTAMPTZ IS NULL OR m.dt <= $6::TIMESTAMPTZ)
+			AND ($7::TEXT IS NULL OR $7::TEXT = ANY(m.pings) OR (m.mass_ping = 'everyone' AND a.username <> $7::TEXT))
+		ORDER BY m.id DESC
+		LIMIT $8;
+	`, pq.Array(chanids), msgid, nullable(filter.Query), nullable(filter.Author), nullable(filter.From), nullable(filter.To), nullable(filter.Mentions), amount)
+	if err != nil {
+		return []*SearchResult{}, err
+	}
+	defer rows.Close()
+	results := []*SearchResult{}
+	for rows.Next() {
+		result := &SearchResult{Message: &ChatMessage{}}
+		msg := result.Message
+		var pings pq.StringArray
+		var massPing sql.NullString
+		var edited sql.NullString
+		var expires sql.NullString
+		var replyTo sql.NullInt64
+		err := rows.Scan(&(result.Channel), &(msg.ID), &(msg.Message), &(msg.Timestamp), &pings, &massPing, &(msg.Author), &(msg.AuthorID), &edited, &expires, &replyTo)
+		if err != nil {
+			return []*SearchResult{}, err
+		}
+		msg.Pings = []string(pings)
+		msg.MassPing = massPing.String
+		msg.Edited = edited.String
+		msg.ExpiresAt = expires.String
+		msg.ReplyTo = int(replyTo.Int64)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// AddReaction - adds a user's reaction to a message. Returns false if it's already there
+func (db *Database) AddReaction(msgid int, username string, emoji string) (bool, error) {
+	res, err := db.db.Exec(`
+		INSERT INTO melodious.reactions (message_id, user_id, emoji)
+		VALUES ($1, (SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1), $3)
+		ON CONFLICT DO NOTHING;
+	`, msgid, username, emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// RemoveReaction - removes a user's reaction from a message. Returns false if there was none
+func (db *Database) RemoveReaction(msgid int, username string, emoji string) (bool, error) {
+	res, err := db.db.Exec(`
+		DELETE FROM melodious.reactions
+		WHERE message_id=$1 AND user_id=(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1) AND emoji=$3;
+	`, msgid, username, emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// FillReactions - sets aggregated reactions of given messages as seen by the given user
+func (db *Database) FillReactions(msgs []*ChatMessage, username string) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	byid := map[int]*ChatMessage{}
+	ids := []int64{}
+	for _, msg := range msgs {
+		byid[msg.ID] = msg
+		ids = append(ids, int64(msg.ID))
+	}
+	rows, err := db.db.Query(`
+		SELECT
+			r.message_id,
+			r.emoji,
+			COUNT(*),
+			BOOL_OR(a.username=$2)
+		FROM melodious.reactions r
+		INNER JOIN melodious.accounts a ON r.user_id = a.id
+		WHERE r.message_id = ANY($1)
+		GROUP BY r.message_id, r.emoji
+		ORDER BY MIN(r.dt) ASC;
+	`, pq.Array(ids), username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msgid int
+		reaction := &Reaction{}
+		err := rows.Scan(&msgid, &(reaction.Emoji), &(reaction.Count), &(reaction.Me))
+		if err != nil {
+			return err
+		}
+		if msg, ok := byid[msgid]; ok {
+			msg.Reactions = append(msg.Reactions, reaction)
+		}
+	}
+	return nil
+}
+
+// AddAttachment - adds an uploaded file to the database unless total size of files uploaded by the uploader would
+// exceed quota bytes. Returns false if it would
+func (db *Database) AddAttachment(attachment *Attachment, channel string, uploader string, quota int64) (bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// the account row is locked so that concurrent uploads of the user are counted one after another
+	row := tx.QueryRow(`
+		SELECT id FROM melodious.accounts WHERE username=$1 FOR UPDATE;
+	`, uploader)
+	var uploaderID int
+	err = row.Scan(&uploaderID)
+	if err != nil {
+		return false, err
+	}
+	row = tx.QueryRow(`
+		SELECT COALESCE(SUM(size), 0) FROM melodious.attachments WHERE uploader_id=$1;
+	`, uploaderID)
+	var used int64
+	err = row.Scan(&used)
+	if err != nil {
+		return false, err
+	}
+	if used+attachment.Size > quota {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO melodious.attachments
+		(id, chan_id, uploader_id, filename, content_type, size, dt)
+		VALUES (
+			$1,
+			(SELECT id FROM melodious.channels WHERE name=$2 LIMIT 1),
+			$3,
+			$4,
+			$5,
+			$6,
+			NOW()
+		);
+	`, attachment.ID, channel, uploaderID, attachment.Filename, attachment.ContentType, attachment.Size)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetAttachment - gets an uploaded file and the channel it was uploaded to by id
+func (db *Database) GetAttachment(id string) (string, *Attachment, error) {
+	row := db.db.QueryRow(`
+		SELECT
+			at.id,
+			at.filename,
+			at.content_type,
+			at.size,
+			c.name channel
+		FROM melodious.attachments at
+		INNER JOIN melodious.channels c ON at.chan_id = c.id
+		WHERE at.id=$1;
+	`, id)
+	attachment := &Attachment{}
+	var channel string
+	err := row.Scan(&(attachment.ID), &(attachment.Filename), &(attachment.ContentType), &(attachment.Size), &channel)
+	if err != nil {
+		return "", &Attachment{}, err
+	}
+	return channel, attachment, nil
+}
+
+// GetUserUploadsSize - gets total size of all files uploaded by a user
+func (db *Database) GetUserUploadsSize(username string) (int64, error) {
+	row := db.db.QueryRow(`
+		SELECT COALESCE(SUM(size), 0) FROM melodious.attachments
+		WHERE uploader_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1);
+	`, username)
+	var size int64
+	err := row.Scan(&size)
+	if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+// DeleteUnattachedUploads - deletes uploaded files which were not attached to a message within the given amount of
+// seconds. Returns their blob IDs
+func (db *Database) DeleteUnattachedUploads(age int) ([]string, error) {
+	rows, err := db.db.Query(`
+		DELETE FROM melodious.attachments
+		WHERE message_id IS NULL AND dt < NOW() - $1::int4 * INTERVAL '1 second'
+		RETURNING id;
+	`, age)
+	if err != nil {
+		return []string{}, err
+	}
+	return scanBlobIDs(rows)
+}
+
+// FindOrphanedBlobs - picks blob IDs which do not belong to any attachment or link preview image
+func (db *Database) FindOrphanedBlobs(ids []string) ([]string, error) {
+	rows, err := db.db.Query(`
+		SELECT b.id FROM UNNEST($1::varchar[]) b(id)
+		WHERE NOT EXISTS(SELECT 1 FROM melodious.attachments at WHERE at.id = b.id)
+			AND NOT EXISTS(SELECT 1 FROM melodious.embed_images e WHERE e.id = b.id);
+	`, pq.Array(ids))
+	if err != nil {
+		return []string{}, err
+	}
+	return scanBlobIDs(rows)
+}
+
+// CanAttach - checks if all given files were uploaded by a user to a channel and are not attached to any message yet
+func (db *Database) CanAttach(ids []string, uploader string, channel string) (bool, error) {
+	row := db.db.QueryRow(`
+		SELECT COUNT(*) FROM melodious.attachments
+		WHERE id = ANY($1)
+			AND uploader_id=(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1)
+			AND chan_id=(SELECT id FROM melodious.channels WHERE name=$3 LIMIT 1)
+			AND message_id IS NULL;
+	`, pq.Array(ids), uploader, channel)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count == len(ids), nil
+}
+
+// FillAttachments - sets attached files of given messages
+func (db *Database) FillAttachments(msgs []*ChatMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	byid := map[int]*ChatMessage{}
+	ids := []int64{}
+	for _, msg := range msgs {
+		byid[msg.ID] = msg
+		ids = append(ids, int64(msg.ID))
+	}
+	rows, err := db.db.Query(`
+		SELECT message_id, id, filename, content_type, size
+		FROM melodious.attachments
+		WHERE message_id = ANY($1)
+		ORDER BY dt ASC;
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msgid int
+		attachment := &Attachment{}
+		err := rows.Scan(&msgid, &(attachment.ID), &(attachment.Filename), &(attachment.ContentType), &(attachment.Size))
+		if err != nil {
+			return err
+		}
+		if msg, ok := byid[msgid]; ok {
+			msg.Attachments = append(msg.Attachments, attachment)
+		}
+	}
+	return nil
+}
+
+// GetCachedEmbed - gets a recently fetched link preview. Embed is nil if the link had nothing to show
+func (db *Database) GetCachedEmbed(url string) (*Embed, bool, error) {
+	row := db.db.QueryRow(`
+		SELECT ok, title, description, image, site_name FROM melodious.link_embeds
+		WHERE url=$1 AND dt > NOW() - INTERVAL '1 day';
+	`, url)
+	var ok bool
+	embed := &Embed{URL: url}
+	err := row.Scan(&ok, &(embed.Title), &(embed.Description), &(embed.Image), &(embed.SiteName))
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, true, nil
+	}
+	return embed, true, nil
+}
+
+// CacheEmbed - stores a fetched link preview. A nil embed marks the link as having nothing to show
+func (db *Database) CacheEmbed(url string, embed *Embed) error {
+	ok := embed != nil
+	if !ok {
+		embed = &Embed{}
+	}
+	_, err := db.db.Exec(`
+		INSERT INTO melodious.link_embeds (url, ok, title, description, image, site_name, dt)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (url) DO UPDATE SET
+			ok=EXCLUDED.ok,
+			title=EXCLUDED.title,
+			description=EXCLUDED.description,
+			image=EXCLUDED.image,
+			site_name=EXCLUDED.site_name,
+			dt=EXCLUDED.dt;
+	`, url, ok, embed.Title, embed.Description, embed.Image, embed.SiteName)
+	return err
+}
+
+// SetMessageEmbeds - sets link previews of a message, replacing previous ones
+func (db *Database) SetMessageEmbeds(msgid int, embeds []*Embed) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		DELETE FROM melodious.message_embeds WHERE message_id=$1;
+	`, msgid)
+	if err != nil {
+		return err
+	}
+	for i, embed := range embeds {
+		_, err = tx.Exec(`
+			INSERT INTO melodious.message_embeds (message_id, position, url, title, description, image, site_name)
+			VALUES ($1, $2, $3, $4, $5, $6, $7);
+		`, msgid, i, embed.URL, embed.Title, embed.Description, embed.Image, embed.SiteName)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddEmbedImage - records a stored link preview image
+func (db *Database) AddEmbedImage(id string, contentType string, size int64) error {
+	_, err := db.db.Exec(`
+		INSERT INTO melodious.embed_images (id, content_type, size, dt) VALUES ($1, $2, $3, NOW());
+	`, id, contentType, size)
+	return err
+}
+
+// GetEmbedImage - gets type and size of a stored link preview image
+func (db *Database) GetEmbedImage(id string) (string, int64, error) {
+	row := db.db.QueryRow(`
+		SELECT content_type, size FROM melodious.embed_images WHERE id=$1;
+	`, id)
+	var contentType string
+	var size int64
+	err := row.Scan(&contentType, &size)
+	if err != nil {
+		return "", 0, err
+	}
+	return contentType, size, nil
+}
+
+// DeleteOldEmbeds - deletes cached link previews older than a day and preview images no longer used by them or by
+// any message. Returns blob IDs of deleted images
+func (db *Database) DeleteOldEmbeds() ([]string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return []string{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM melodious.link_embeds WHERE dt <= NOW() - INTERVAL '1 day';
+	`)
+	if err != nil {
+		return []string{}, err
+	}
+	// images stored recently might not be referenced yet
+	rows, err := tx.Query(`
+		DELETE FROM melodious.embed_images e
+		WHERE e.dt < NOW() - INTERVAL '1 hour'
+			AND NOT EXISTS(SELECT 1 FROM melodious.link_embeds l WHERE l.image = e.id)
+			AND NOT EXISTS(SELECT 1 FROM melodious.message_embeds m WHERE m.image = e.id)
+		RETURNING e.id;
+	`)
+	if err != nil {
+		return []string{}, err
+	}
+	blobs, err := scanBlobIDs(rows)
+	if err != nil {
+		return []string{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return []string{}, err
+	}
+	return blobs, nil
+}
+
+// FillEmbeds - sets link previews of given messages
+func (db *Database) FillEmbeds(msgs []*ChatMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	byid := map[int]*ChatMessage{}
+	ids := []int64{}
+	for _, msg := range msgs {
+		byid[msg.ID] = msg
+		ids = append(ids, int64(msg.ID))
+	}
+	rows, err := db.db.Query(`
+		SELECT message_id, url, title, description, image, site_name
+		FROM melodious.message_embeds
+		WHERE message_id = ANY($1)
+		ORDER BY position ASC;
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msgid int
+		embed := &Embed{}
+		err := rows.Scan(&msgid, &(embed.URL), &(embed.Title), &(embed.Description), &(embed.Image), &(embed.SiteName))
+		if err != nil {
+			return err
+		}
+		if msg, ok := byid[msgid]; ok {
+			msg.Embeds = append(msg.Embeds, embed)
+		}
+	}
+	return nil
+}
+
+// PinMessage - pins a message in its channel. Returns false if it is already pinned
+func (db *Database) PinMessage(msgid int, pinner string) (bool, error) {
+	res, err := db.db.Exec(`
+		INSERT INTO melodious.pins (message_id, chan_id, pinner_id, dt)
+		VALUES (
+			$1,
+			(SELECT chan_id FROM melodious.messages WHERE id=$1 LIMIT 1),
+			(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1),
+			NOW()
+		)
+		ON CONFLICT DO NOTHING;
+	`, msgid, pinner)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// UnpinMessage - unpins a message. Returns false if it is not pinned
+func (db *Database) UnpinMessage(msgid int) (bool, error) {
+	res, err := db.db.Exec(`
+		DELETE FROM melodious.pins WHERE message_id=$1;
+	`, msgid)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// GetPins - gets pinned messages of a channel, most recently pinned first
+func (db *Database) GetPins(channel string) ([]*ChatMessage, error) {
+	rows, err := db.db.Query(`
+		SELECT
+			m.id,
+			m.message,
+			m.dt,
+			m.pings,
+			m.mass_ping,
+			a.username author,
+			m.author_id,
+			m.edited,
+			m.expires_at,
+			m.reply_to,
+			(SELECT COUNT(*) FROM melodious.messages r WHERE r.reply_to = m.id) reply_count
+		FROM melodious.pins p
+		INNER JOIN melodious.messages m ON p.message_id = m.id
+		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		WHERE p.chan_id=(SELECT id FROM melodious.channels WHERE name=$1 LIMIT 1)
+		ORDER BY p.dt DESC;
+	`, channel)
+	if err != nil {
+		return []*ChatMessage{}, err
+	}
+	defer rows.Close()
+	msgs := []*ChatMessage{}
+	for rows.Next() {
+		msg := &ChatMessage{}
+		var pings pq.StringArray
+		var massPing sql.NullString
+		var edited sql.NullString
+		var expires sql.NullString
+		var replyTo sql.NullInt64
+		err := rows.Scan(&(msg.ID), &(msg.Message), &(msg.Timestamp), &pings, &massPing, &(msg.Author), &(msg.AuthorID), &edited, &expires, &replyTo, &(msg.ReplyCount))
+		if err != nil {
+			return []*ChatMessage{}, err
+		}
+		msg.Pings = []string(pings)
+		msg.MassPing = massPing.String
+		msg.Edited = edited.String
+		msg.ExpiresAt = expires.String
+		msg.ReplyTo = int(replyTo.Int64)
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// CreatePoll - posts a message with a poll
+func (db *Database) CreatePoll(channel string, author string, poll *Poll) (*ChatMessage, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`
+		INSERT INTO melodious.messages
+		(chan_id, message, dt, pings, author_id)
+		VALUES (
+			(SELECT id FROM melodious.channels WHERE name=$1 LIMIT 1),
+			$2,
+			NOW(),
+			'{}',
+			(SELECT id FROM melodious.accounts WHERE username=$3 LIMIT 1)
+		)
+		RETURNING message, id, dt, $3, author_id;
+	`, channel, poll.Question, author)
+	msg := &ChatMessage{Pings: []string{}}
+	err = row.Scan(&(msg.Message), &(msg.ID), &(msg.Timestamp), &(msg.Author), &(msg.AuthorID))
+	if err != nil {
+		return nil, err
+	}
+	options := []string{}
+	for _, option := range poll.Options {
+		options = append(options, option.Text)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO melodious.polls (message_id, question, options, multi, ends, closed)
+		VALUES ($1, $2, $3, $4, $5, false);
+	`, msg.ID, poll.Question, pq.Array(options), poll.Multi, poll.Ends)
+	if err != nil {
+		return nil, err
+	}
+	return msg, tx.Commit()
+}
+
+// FillPolls - sets polls of given messages with current results. Votes of the given user are marked
+func (db *Database) FillPolls(msgs []*ChatMessage, username string) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	byid := map[int]*ChatMessage{}
+	ids := []int64{}
+	for _, msg := range msgs {
+		byid[msg.ID] = msg
+		ids = append(ids, int64(msg.ID))
+	}
+	rows, err := db.db.Query(`
+		SELECT message_id, question, options, multi, ends, closed OR ends <= NOW()
+		FROM melodious.polls
+		WHERE message_id = ANY($1);
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msgid int
+		var options pq.StringArray
+		poll := &Poll{Options: []*PollOption{}}
+		err := rows.Scan(&msgid, &(poll.Question), &options, &(poll.Multi), &(poll.Ends), &(poll.Closed))
+		if err != nil {
+			return err
+		}
+		for _, option := range options {
+			poll.Options = append(poll.Options, &PollOption{Text: option})
+		}
+		if msg, ok := byid[msgid]; ok {
+			msg.Poll = poll
+		}
+	}
+	rows, err = db.db.Query(`
+		SELECT
+			message_id,
+			option,
+			COUNT(*),
+			COALESCE(BOOL_OR(user_id=(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1)), false)
+		FROM melodious.poll_votes
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, option
+		ORDER BY message_id, option;
+	`, pq.Array(ids), username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msgid, option, votes int
+		var mine bool
+		err := rows.Scan(&msgid, &option, &votes, &mine)
+		if err != nil {
+			return err
+		}
+		msg, ok := byid[msgid]
+		if !ok || msg.Poll == nil || option < 0 || option >= len(msg.Poll.Options) {
+			continue
+		}
+		msg.Poll.Options[option].Votes = votes
+		if mine {
+			msg.Poll.MyVotes = append(msg.Poll.MyVotes, option)
+		}
+	}
+	return nil
+}
+
+// ErrPollClosed - returned when votes are changed in a poll which is closed or has ended
+var ErrPollClosed = errors.New("poll is closed")
+
+// SetPollVotes - replaces votes of a user in a poll with the given options. Empty options retract the votes. Returns
+// ErrPollClosed if the poll does not accept votes anymore
+func (db *Database) SetPollVotes(msgid int, username string, options []int) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the poll row stays locked until commit, so it cannot be closed while votes are changed
+	var open bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM melodious.polls WHERE message_id=$1 AND NOT closed AND ends > NOW() FOR SHARE
+		);
+	`, msgid).Scan(&open)
+	if err != nil {
+		return err
+	} else if !open {
+		return ErrPollClosed
+	}
+	_, err = tx.Exec(`
+		DELETE FROM melodious.poll_votes
+		WHERE message_id=$1 AND user_id=(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1);
+	`, msgid, username)
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		_, err = tx.Exec(`
+			INSERT INTO melodious.poll_votes (message_id, user_id, option, dt)
+			VALUES ($1, (SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1), $3, NOW());
+		`, msgid, username, option)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CloseDuePolls - closes polls whose end time has come. Returns ids of their messages
+func (db *Database) CloseDuePolls() ([]int, error) {
+	rows, err := db.db.Query(`
+		UPDATE melodious.polls SET closed=true
+		WHERE NOT closed AND ends <= NOW()
+		RETURNING message_id;
+	`)
+	if err != nil {
+		return []int{}, err
+	}
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return []int{}, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetMessageDetails - gets a message and the channel it's from by id
+func (db *Database) GetMessageDetails(id int) (string, *ChatMessage, error) {
+	row := db.db.QueryRow(`
+		SELECT
+			m.message,
+			m.dt,
+			m.pings,
+			m.mass_ping,
+			a.username author,
+			m.author_id,
+			m.edited,
+			m.expires_at,
+			m.reply_to,
+			(SELECT COUNT(*) FROM melodious.messages r WHERE r.reply_to = m.id) reply_count,
+			c.name channel
+		FROM melodious.messages m
+		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		INNER JOIN melodious.channels c ON m.chan_id = c.id
+		WHERE m.id=$1;
+	`, id)
+	var pings pq.StringArray
+	var massPing sql.NullString
+	var edited sql.NullString
+	var expires sql.NullString
+	var replyTo sql.NullInt64
+	var channel string
+	msg := &ChatMessage{}
+	err := row.Scan(&(msg.Message), &(msg.Timestamp), &pings, &massPing, &(msg.Author), &(msg.AuthorID), &edited, &expires, &replyTo, &(msg.ReplyCount), &channel)
+	if err != nil {
+		return "", &ChatMessage{}, err
+	}
+	msg.Pings = []string(pings)
+	msg.MassPing = massPing.String
+	msg.Edited = edited.String
+	msg.ExpiresAt = expires.String
+	msg.ReplyTo = int(replyTo.Int64)
+	msg.ID = id
+	return channel, msg, nil
+}
+
+// dmConversationQuery - selects the conversation with exactly the participants given as a sorted array
+const dmConversationQuery = `
+	SELECT p.conv_id
+	FROM melodious.dm_participants p
+	INNER JOIN melodious.accounts a ON p.user_id = a.id
+	GROUP BY p.conv_id
+	HAVING ARRAY_AGG(a.username::TEXT ORDER BY a.username::TEXT COLLATE "C") = $1::TEXT[]
+	LIMIT 1;
+`
+
+// GetDMConversation - gets id of the conversation with exactly the given participants
+func (db *Database) GetDMConversation(usernames []string) (int, error) {
+	sorted := append([]string{}, usernames...)
+	sort.Strings(sorted)
+	row := db.db.QueryRow(dmConversationQuery, pq.Array(sorted))
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// NewDMConversation - creates a conversation between given users. If another one with the same participants was
+// created in the meantime, it is returned instead. Returns its id
+func (db *Database) NewDMConversation(usernames []string) (int, error) {
+	sorted := append([]string{}, usernames...)
+	sort.Strings(sorted)
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	// serializes creation of conversations between the same users until the end of the transaction
+	_, err = tx.Exec(`
+		SELECT pg_advisory_xact_lock(hashtext('dm ' || ARRAY_TO_STRING($1::TEXT[], ' ')));
+	`, pq.Array(sorted))
+	if err != nil {
+		return -1, err
+	}
+	var id int
+	err = tx.QueryRow(dmConversationQuery, pq.Array(sorted)).Scan(&id)
+	if err == nil {
+		return id, tx.Commit()
+	} else if err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	row := tx.QueryRow(`
+		INSERT INTO melodious.dm_conversations (created) VALUES (NOW()) RETURNING id;
+	`)
+	err = row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO melodious.dm_participants (conv_id, user_id)
+		SELECT $1, id FROM melodious.accounts WHERE username = ANY($2);
+	`, id, pq.Array(sorted))
+	if err != nil {
+		return -1, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// GetDMParticipants - gets usernames of all participants of a conversation
+func (db *Database) GetDMParticipants(convid int) ([]string, error) {
+	rows, err := db.db.Query(`
+		SELECT a.username
+		FROM melodious.dm_participants p
+		INNER JOIN melodious.accounts a ON p.user_id = a.id
+		WHERE p.conv_id=$1
+		ORDER BY a.username;
+	`, convid)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+	participants := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return []string{}, err
+		}
+		participants = append(participants, username)
+	}
+	return participants, nil
+}
+
+// ListDMConversations - gets all conversations the given user participates in
+func (db *Database) ListDMConversations(username string) ([]*DMConversation, error) {
+	rows, err := db.db.Query(`
+		SELECT p.conv_id, ARRAY_AGG(a.username ORDER BY a.username)
+		FROM melodious.dm_participants p
+		INNER JOIN melodious.accounts a ON p.user_id = a.id
+		WHERE p.conv_id IN (
+			SELECT conv_id FROM melodious.dm_participants
+			WHERE user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+		)
+		GROUP BY p.conv_id
+		ORDER BY p.conv_id;
+	`, username)
+	if err != nil {
+		return []*DMConversation{}, err
+	}
+	defer rows.Close()
+	convs := []*DMConversation{}
+	for rows.Next() {
+		conv := &DMConversation{}
+		var participants pq.StringArray
+		if err := rows.Scan(&(conv.ID), &participants); err != nil {
+			return []*DMConversation{}, err
+		}
+		conv.Participants = []string(participants)
+		convs = append(convs, conv)
+	}
+	return convs, nil
+}
+
+// PostDM - posts a new direct message to a conversation
+func (db *Database) PostDM(convid int, message string, author string) (*ChatMessage, error) {
+	row := db.db.QueryRow(`
+		INSERT INTO melodious.dm_messages
+		(conv_id, message, dt, author_id)
+		VALUES (
+			$1,
+			$2,
+			NOW(),
+			(SELECT id FROM melodious.accounts WHERE username=$3 LIMIT 1)
+		)
+		RETURNING message, id, dt, $3, author_id;
+	`, convid, message, author)
+	msg := &ChatMessage{Pings: []string{}}
+	err := row.Scan(&(msg.Message), &(msg.ID), &(msg.Timestamp), &(msg.Author), &(msg.AuthorID))
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GetDMHistory - gets last n direct messages in a conversation starting from an id
+func (db *Database) GetDMHistory(convid int, msgid int, amount int) ([]*ChatMessage, error) {
+	rows, err := db.db.Query(`
+		SELECT
+			m.id,
+			m.message,
+			m.dt,
+			a.username author,
+			m.author_id
+		FROM melodious.dm_messages m
+		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		WHERE m.conv_id=$1 AND m.id<$2
+		ORDER BY m.id DESC
+		LIMIT $3;
+	`, convid, msgid, amount)
+	if err != nil {
+		return []*ChatMessage{}, err
+	}
+	defer rows.Close()
+	msgs := []*ChatMessage{}
+	for rows.Next() {
+		msg := &ChatMessage{Pings: []string{}}
+		err := rows.Scan(&(msg.ID), &(msg.Message), &(msg.Timestamp), &(msg.Author), &(msg.AuthorID))
+		if err != nil {
+			return []*ChatMessage{}, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// MarkRead - moves a user's read marker in a channel forward to the given message id.
+// Returns the resulting last read message id
+func (db *Database) MarkRead(username string, channel string, msgid int) (int, error) {
+	row := db.db.QueryRow(`
+		INSERT INTO melodious.read_markers (user_id, chan_id, message_id, dt)
+		VALUES (
+			(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1),
+			(SELECT id FROM melodious.channels WHERE name=$2 LIMIT 1),
+			$3,
+			NOW()
+		)
+		ON CONFLICT (user_id, chan_id) DO UPDATE
+		SET message_id=GREATEST(read_markers.message_id, EXCLUDED.message_id), dt=EXCLUDED.dt
+		RETURNING message_id;
+	`, username, channel, msgid)
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// GetUnreadSummary - counts unread messages and mentions of a user in given channels
+func (db *Database) GetUnreadSummary(username string, chanids []int) ([]*UnreadSummary, error) {
+	ids := []int64{}
+	for _, id := range chanids {
+		ids = append(ids, int64(id))
+	}
+	rows, err := db.db.Query(`
+		SELECT
+			c.id,
+			c.name,
+			COALESCE(r.message_id, 0),
+			(SELECT COUNT(*) FROM melodious.messages m
+				WHERE m.chan_id=c.id AND m.id>COALESCE(r.message_id, 0) AND m.author_id<>u.id),
+			(SELECT COUNT(*) FROM melodious.messages m
+				WHERE m.chan_id=c.id AND m.id>COALESCE(r.message_id, 0) AND m.author_id<>u.id
+					AND (u.username=ANY(m.pings) OR m.mass_ping='everyone'))
+		FROM melodious.channels c
+		CROSS JOIN (SELECT id, username FROM melodious.accounts WHERE username=$2 LIMIT 1) u
+		LEFT JOIN melodious.read_markers r ON r.chan_id=c.id AND r.user_id=u.id
+		WHERE c.id = ANY($1)
+		ORDER BY c.id;
+	`, pq.Array(ids), username)
+	if err != nil {
+		return []*UnreadSummary{}, err
+	}
+	defer rows.Close()
+	summaries := []*UnreadSummary{}
+	for rows.Next() {
+		summary := &UnreadSummary{}
+		err := rows.Scan(&(summary.ChannelID), &(summary.Channel), &(summary.LastRead), &(summary.Unread), &(summary.Mentions))
+		if err != nil {
+			return []*UnreadSummary{}, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// AddToInbox - stores a mention of users in their inboxes
+func (db *Database) AddToInbox(msgid int, usernames []string) error {
+	_, err := db.db.Exec(`
+		INSERT INTO melodious.inbox (user_id, message_id, acked, dt)
+		SELECT a.id, $1, false, NOW() FROM melodious.accounts a WHERE a.username = ANY($2)
+		ON CONFLICT DO NOTHING;
+	`, msgid, pq.Array(usernames))
+	return err
+}
+
+// GetInbox - gets last n inbox entries of a user before an entry id, newest first, skipping channels the user cannot read
+func (db *Database) GetInbox(username string, entryid int, amount int, unackedOnly bool) ([]*InboxEntry, error) {
+	if entryid <= 0 {
+		entryid = math.MaxInt32
+	}
+	rows, err := db.db.Query(`
+		SELECT
+			i.id,
+			c.name channel,
+			i.acked,
+			i.dt,
+			m.id,
+			m.message,
+			m.dt,
+			m.pings,
+			m.mass_ping,
+			a.username author,
+			m.author_id,
+			m.edited,
+			m.expires_at,
+			m.reply_to,
+			(SELECT COUNT(*) FROM melodious.messages r WHERE r.reply_to = m.id) reply_count
+		FROM melodious.inbox i
+		INNER JOIN melodious.messages m ON i.message_id = m.id
+		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		INNER JOIN melodious.channels c ON m.chan_id = c.id
+		WHERE i.user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+			AND i.id<$2
+			AND (NOT $4 OR NOT i.acked)
+			AND (
+				EXISTS(SELECT 1 FROM melodious.accounts WHERE username=$1 AND owner) OR
+				EXISTS(SELECT 1 FROM melodious.query_flags($1, c.name, '', 'perms.get-messages', true))
+			)
+		ORDER BY i.id DESC
+		LIMIT $3;
+	`, username, entryid, amount, unackedOnly)
+	if err != nil {
+		return []*InboxEntry{}, err
+	}
+	defer rows.Close()
+	entries := []*InboxEntry{}
+	for rows.Next() {
+		entry := &InboxEntry{Message: &ChatMessage{}}
+		msg := entry.Message
 		var pings pq.StringArray
-		err := rows.Scan(&(msg.ID), &(msg.Message), &(msg.Timestamp), &pings, &(msg.Author), &(msg.AuthorID))
+		var massPing sql.NullString
+		var edited sql.NullString
+		var expires sql.NullString
+		var replyTo sql.NullInt64
+		err := rows.Scan(&(entry.ID), &(entry.Channel), &(entry.Acked), &(entry.Timestamp),
+			&(msg.ID), &(msg.Message), &(msg.Timestamp), &pings, &massPing, &(msg.Author), &(msg.AuthorID), &edited, &expires, &replyTo, &(msg.ReplyCount))
 		if err != nil {
-			return []*ChatMessage{}, err
+			return []*InboxEntry{}, err
 		}
 		msg.Pings = []string(pings)
-		msgs = append(msgs, msg)
+		msg.MassPing = massPing.String
+		msg.Edited = edited.String
+		msg.ExpiresAt = expires.String
+		msg.ReplyTo = int(replyTo.Int64)
+		entries = append(entries, entry)
 	}
-	return msgs, nil
+	return entries, nil
 }
 
-// GetMessageDetails - gets a message and the channel it's from by id
-func (db *Database) GetMessageDetails(id int) (string, *ChatMessage, error) {
+// AckInbox - acknowledges inbox entries of a user; all of them if ids is nil. Returns amount of acknowledged entries
+func (db *Database) AckInbox(username string, ids []int) (int, error) {
+	var entryids interface{}
+	if ids != nil {
+		array := []int64{}
+		for _, id := range ids {
+			array = append(array, int64(id))
+		}
+		entryids = pq.Array(array)
+	}
+	res, err := db.db.Exec(`
+		UPDATE melodious.inbox SET acked=true
+		WHERE user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+			AND NOT acked
+			AND ($2::int8[] IS NULL OR id = ANY($2));
+	`, username, entryids)
+	if err != nil {
+		return -1, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+	return int(n), nil
+}
+
+// GetInboxCount - counts unacknowledged inbox entries of a user in channels they can read
+func (db *Database) GetInboxCount(username string) (int, error) {
 	row := db.db.QueryRow(`
-		SELECT
-			m.message,
-			m.dt,
-			m.pings,
-			a.username author,
-			m.author_id,
-			c.name channel
-		FROM melodious.messages m
-		INNER JOIN melodious.accounts a ON m.author_id = a.id
+		SELECT COUNT(*) FROM melodious.inbox i
+		INNER JOIN melodious.messages m ON i.message_id = m.id
 		INNER JOIN melodious.channels c ON m.chan_id = c.id
-		WHERE m.id=$1;
-	`, id)
-	var pings pq.StringArray
-	var channel string
-	msg := &ChatMessage{}
-	err := row.Scan(&(msg.Message), &(msg.Timestamp), &pings, &(msg.Author), &(msg.AuthorID), &channel)
+		WHERE i.user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1) AND NOT i.acked AND (
+			EXISTS(SELECT 1 FROM melodious.accounts WHERE username=$1 AND owner) OR
+			EXISTS(SELECT 1 FROM melodious.query_flags($1, c.name, '', 'perms.get-messages', true))
+		);
+	`, username)
+	var count int
+	err := row.Scan(&count)
 	if err != nil {
-		return "", &ChatMessage{}, err
+		return -1, err
 	}
-	msg.Pings = []string(pings)
-	msg.ID = id
-	return channel, msg, nil
+	return count, nil
+}
+
+// SetNotifyPrefs - sets notification preferences of a user in a channel. Nil values are left unchanged
+func (db *Database) SetNotifyPrefs(username string, channel string, level interface{}, muted interface{}) (*NotifyPrefs, error) {
+	row := db.db.QueryRow(`
+		INSERT INTO melodious.notify_prefs (user_id, chan_id, level, muted)
+		VALUES (
+			(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1),
+			(SELECT id FROM melodious.channels WHERE name=$2 LIMIT 1),
+			COALESCE($3, 'mentions'),
+			COALESCE($4, false)
+		)
+		ON CONFLICT (user_id, chan_id) DO UPDATE
+		SET level=COALESCE($3, notify_prefs.level), muted=COALESCE($4, notify_prefs.muted)
+		RETURNING level, muted;
+	`, username, channel, level, muted)
+	prefs := &NotifyPrefs{Channel: channel}
+	err := row.Scan(&(prefs.Level), &(prefs.Muted))
+	if err != nil {
+		return &NotifyPrefs{}, err
+	}
+	return prefs, nil
+}
+
+// GetNotifyPrefs - gets notification preferences of a user in all channels they were set in
+func (db *Database) GetNotifyPrefs(username string) ([]*NotifyPrefs, error) {
+	rows, err := db.db.Query(`
+		SELECT c.name, np.level, np.muted
+		FROM melodious.notify_prefs np
+		INNER JOIN melodious.channels c ON np.chan_id = c.id
+		WHERE np.user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+		ORDER BY c.id;
+	`, username)
+	if err != nil {
+		return []*NotifyPrefs{}, err
+	}
+	defer rows.Close()
+	prefs := []*NotifyPrefs{}
+	for rows.Next() {
+		p := &NotifyPrefs{}
+		err := rows.Scan(&(p.Channel), &(p.Level), &(p.Muted))
+		if err != nil {
+			return []*NotifyPrefs{}, err
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, nil
+}
+
+// GetChannelNotifyPrefs - gets notification preferences of all users who set them in a channel, by username
+func (db *Database) GetChannelNotifyPrefs(channel string) (map[string]*NotifyPrefs, error) {
+	rows, err := db.db.Query(`
+		SELECT a.username, np.level, np.muted
+		FROM melodious.notify_prefs np
+		INNER JOIN melodious.accounts a ON np.user_id = a.id
+		WHERE np.chan_id=(SELECT id FROM melodious.channels WHERE name=$1 LIMIT 1);
+	`, channel)
+	if err != nil {
+		return map[string]*NotifyPrefs{}, err
+	}
+	defer rows.Close()
+	prefs := map[string]*NotifyPrefs{}
+	for rows.Next() {
+		var username string
+		p := &NotifyPrefs{Channel: channel}
+		err := rows.Scan(&username, &(p.Level), &(p.Muted))
+		if err != nil {
+			return map[string]*NotifyPrefs{}, err
+		}
+		prefs[username] = p
+	}
+	return prefs, nil
+}
+
+// AddHighlight - adds a highlight of a user. Returns its id
+func (db *Database) AddHighlight(username string, pattern string, regex bool) (int, error) {
+	row := db.db.QueryRow(`
+		INSERT INTO melodious.highlights (user_id, pattern, regex)
+		VALUES ((SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1), $2, $3)
+		RETURNING id;
+	`, username, pattern, regex)
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// DeleteHighlight - deletes a highlight of a user. Returns false if the user has no such highlight
+func (db *Database) DeleteHighlight(username string, id int) (bool, error) {
+	res, err := db.db.Exec(`
+		DELETE FROM melodious.highlights
+		WHERE id=$2 AND user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1);
+	`, username, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// GetHighlights - gets highlights of a user
+func (db *Database) GetHighlights(username string) ([]*Highlight, error) {
+	rows, err := db.db.Query(`
+		SELECT id, pattern, regex FROM melodious.highlights
+		WHERE user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+		ORDER BY id;
+	`, username)
+	if err != nil {
+		return []*Highlight{}, err
+	}
+	defer rows.Close()
+	highlights := []*Highlight{}
+	for rows.Next() {
+		h := &Highlight{}
+		err := rows.Scan(&(h.ID), &(h.Pattern), &(h.Regex))
+		if err != nil {
+			return []*Highlight{}, err
+		}
+		highlights = append(highlights, h)
+	}
+	return highlights, nil
+}
+
+// GetAllHighlights - gets highlights of all users, by username
+func (db *Database) GetAllHighlights() (map[string][]*Highlight, error) {
+	rows, err := db.db.Query(`
+		SELECT a.username, h.id, h.pattern, h.regex
+		FROM melodious.highlights h
+		INNER JOIN melodious.accounts a ON h.user_id = a.id;
+	`)
+	if err != nil {
+		return map[string][]*Highlight{}, err
+	}
+	defer rows.Close()
+	highlights := map[string][]*Highlight{}
+	for rows.Next() {
+		var username string
+		h := &Highlight{}
+		err := rows.Scan(&username, &(h.ID), &(h.Pattern), &(h.Regex))
+		if err != nil {
+			return map[string][]*Highlight{}, err
+		}
+		highlights[username] = append(highlights[username], h)
+	}
+	return highlights, nil
+}
+
+// AddScheduled - schedules a message to a channel or a reminder. Returns its id
+func (db *Database) AddScheduled(item *ScheduledItem) (int, error) {
+	var channel, msgid interface{}
+	if item.Channel != "" {
+		channel = item.Channel
+	}
+	if item.MessageID != 0 {
+		msgid = item.MessageID
+	}
+	row := db.db.QueryRow(`
+		INSERT INTO melodious.scheduled (kind, user_id, chan_id, content, message_id, due)
+		VALUES (
+			$1,
+			(SELECT id FROM melodious.accounts WHERE username=$2 LIMIT 1),
+			(SELECT id FROM melodious.channels WHERE name=$3 LIMIT 1),
+			$4,
+			$5,
+			$6
+		)
+		RETURNING id;
+	`, item.Kind, item.Author, channel, item.Content, msgid, item.Due)
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// scanScheduled - reads scheduled items selected by queries below
+func scanScheduled(rows *sql.Rows) ([]*ScheduledItem, error) {
+	defer rows.Close()
+	items := []*ScheduledItem{}
+	for rows.Next() {
+		item := &ScheduledItem{}
+		var channel, failure sql.NullString
+		var msgid sql.NullInt64
+		err := rows.Scan(&(item.ID), &(item.Kind), &(item.Author), &channel, &(item.Content), &msgid, &(item.Due), &failure)
+		if err != nil {
+			return []*ScheduledItem{}, err
+		}
+		item.Channel = channel.String
+		item.MessageID = int(msgid.Int64)
+		item.Failure = failure.String
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// GetScheduled - gets pending scheduled items of a user, soonest first
+func (db *Database) GetScheduled(username string) ([]*ScheduledItem, error) {
+	rows, err := db.db.Query(`
+		SELECT s.id, s.kind, a.username, COALESCE(c.name, mc.name), s.content, s.message_id, s.due, s.failure
+		FROM melodious.scheduled s
+		INNER JOIN melodious.accounts a ON s.user_id = a.id
+		LEFT JOIN melodious.channels c ON s.chan_id = c.id
+		LEFT JOIN melodious.messages m ON s.message_id = m.id
+		LEFT JOIN melodious.channels mc ON m.chan_id = mc.id
+		WHERE a.username=$1 AND s.kind<>'failed'
+		ORDER BY s.due, s.id;
+	`, username)
+	if err != nil {
+		return []*ScheduledItem{}, err
+	}
+	return scanScheduled(rows)
+}
+
+// CountScheduled - counts pending scheduled items of a user
+func (db *Database) CountScheduled(username string) (int, error) {
+	row := db.db.QueryRow(`
+		SELECT COUNT(*) FROM melodious.scheduled
+		WHERE kind<>'failed' AND user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1);
+	`, username)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return -1, err
+	}
+	return count, nil
+}
+
+// CancelScheduled - cancels a pending scheduled item of a user. Returns false if the user has no such item
+func (db *Database) CancelScheduled(username string, id int) (bool, error) {
+	res, err := db.db.Exec(`
+		DELETE FROM melodious.scheduled
+		WHERE id=$2 AND user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1);
+	`, username, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// TakeDueMessage - locks a scheduled message whose time has come, except those in skip, and calls prepare with it. The
+// message is posted and removed in the same transaction if prepare allows it, is marked as failed if prepare refuses it,
+// or is postponed by the returned delay if it cannot be posted yet. If prepare returns an error, the message stays
+// scheduled and is retried later. Returns the posted message, if any, and false if there is no such message
+func (db *Database) TakeDueMessage(skip []int, prepare func(item *ScheduledItem) (*ScheduledPost, error)) (*ChatMessage, bool, error) {
+	if skip == nil {
+		skip = []int{}
+	}
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// other instances skip messages locked here, so every message is posted once
+	rows, err := tx.Query(`
+		SELECT s.id, s.kind, a.username, c.name, s.content, s.message_id, s.due, s.failure
+		FROM melodious.scheduled s
+		INNER JOIN melodious.accounts a ON s.user_id = a.id
+		INNER JOIN melodious.channels c ON s.chan_id = c.id
+		WHERE s.kind='message' AND s.due <= NOW() AND NOT (s.id = ANY($1::int4[]))
+		ORDER BY s.due, s.id
+		LIMIT 1
+		FOR UPDATE OF s SKIP LOCKED;
+	`, pq.Array(skip))
+	if err != nil {
+		return nil, false, err
+	}
+	items, err := scanScheduled(rows)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(items) == 0 {
+		return nil, false, tx.Commit()
+	}
+	item := items[0]
+
+	post, err := prepare(item)
+	if err != nil {
+		return nil, true, err
+	}
+	var msg *ChatMessage
+	if post.Delay != 0 {
+		_, err = tx.Exec(`
+			UPDATE melodious.scheduled SET due=NOW() + $2::int4 * INTERVAL '1 second' WHERE id=$1;
+		`, item.ID, post.Delay)
+	} else if post.Failure != "" {
+		_, err = tx.Exec(`
+			UPDATE melodious.scheduled SET kind='failed', failure=$2 WHERE id=$1;
+		`, item.ID, post.Failure)
+	} else {
+		// the message is posted only if it is removed as well, so it cannot be posted twice
+		msg, err = insertMessage(tx, item.Channel, item.Content, post.Pings, post.MassPing, item.Author, 0, 0)
+		if err != nil {
+			return nil, true, err
+		}
+		_, err = tx.Exec(`
+			DELETE FROM melodious.scheduled WHERE id=$1;
+		`, item.ID)
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, true, err
+	}
+	return msg, true, nil
+}
+
+// TakeFailedMessages - removes scheduled messages of a user which could not be posted and returns them
+func (db *Database) TakeFailedMessages(username string) ([]*ScheduledItem, error) {
+	rows, err := db.db.Query(`
+		WITH failed AS (
+			DELETE FROM melodious.scheduled
+			WHERE kind='failed' AND user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+			RETURNING id, kind, user_id, chan_id, content, message_id, due, failure
+		)
+		SELECT failed.id, failed.kind, a.username, c.name, failed.content, failed.message_id, failed.due, failed.failure
+		FROM failed
+		INNER JOIN melodious.accounts a ON failed.user_id = a.id
+		LEFT JOIN melodious.channels c ON failed.chan_id = c.id
+		ORDER BY failed.due, failed.id;
+	`, username)
+	if err != nil {
+		return []*ScheduledItem{}, err
+	}
+	return scanScheduled(rows)
+}
+
+// TakeDueReminders - removes reminders of a user whose time has come and returns them
+func (db *Database) TakeDueReminders(username string) ([]*ScheduledItem, error) {
+	rows, err := db.db.Query(`
+		WITH due AS (
+			DELETE FROM melodious.scheduled
+			WHERE kind='reminder' AND due <= NOW()
+				AND user_id=(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1)
+			RETURNING id, kind, user_id, content, message_id, due, failure
+		)
+		SELECT due.id, due.kind, a.username, mc.name, due.content, due.message_id, due.due, due.failure
+		FROM due
+		INNER JOIN melodious.accounts a ON due.user_id = a.id
+		LEFT JOIN melodious.messages m ON due.message_id = m.id
+		LEFT JOIN melodious.channels mc ON m.chan_id = mc.id
+		ORDER BY due.due, due.id;
+	`, username)
+	if err != nil {
+		return []*ScheduledItem{}, err
+	}
+	return scanScheduled(rows)
+}
+
+// GetUsersWithDueReminders - gets names of users who have reminders whose time has come or scheduled messages which
+// could not be posted
+func (db *Database) GetUsersWithDueReminders() ([]string, error) {
+	rows, err := db.db.Query(`
+		SELECT DISTINCT a.username
+		FROM melodious.scheduled s
+		INNER JOIN melodious.accounts a ON s.user_id = a.id
+		WHERE (s.kind='reminder' AND s.due <= NOW()) OR s.kind='failed';
+	`)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		err := rows.Scan(&username)
+		if err != nil {
+			return []string{}, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, nil
+}
+
+// AddAuditEntry - records a privileged action. before and after are JSON documents or nil
+func (db *Database) AddAuditEntry(actor string, action string, target string, channel string, reason string, before []byte, after []byte) error {
+	// make sure we pass NULL to PostgreSQL for missing values
+	var ch, b, a interface{}
+	if channel != "" {
+		ch = channel
+	}
+	if before != nil {
+		b = string(before)
+	}
+	if after != nil {
+		a = string(after)
+	}
+	_, err := db.db.Exec(`
+		INSERT INTO melodious.audit_log (actor_id, actor, action, target, channel, reason, before, after, dt)
+		VALUES (
+			(SELECT id FROM melodious.accounts WHERE username=$1 LIMIT 1),
+			$1, $2, $3, $4, $5, $6::jsonb, $7::jsonb, NOW()
+		);
+	`, actor, action, target, ch, reason, b, a)
+	return err
+}
+
+// GetAuditLog - gets last n audit log entries matching the filter before an entry id, newest first
+func (db *Database) GetAuditLog(filter *AuditFilter, entryid int, amount int) ([]*AuditEntry, error) {
+	if entryid <= 0 {
+		entryid = math.MaxInt32
+	}
+	// make sure we pass NULL to PostgreSQL for unset filters
+	var actor, action, target, channel interface{}
+	if filter.Actor != "" {
+		actor = filter.Actor
+	}
+	if filter.Action != "" {
+		action = filter.Action
+	}
+	if filter.Target != "" {
+		target = filter.Target
+	}
+	if filter.Channel != "" {
+		channel = filter.Channel
+	}
+	rows, err := db.db.Query(`
+		SELECT id, actor, action, target, channel, reason, before, after, dt
+		FROM melodious.audit_log
+		WHERE id<$1
+			AND ($3::varchar IS NULL OR actor=$3)
+			AND ($4::varchar IS NULL OR action=$4)
+			AND ($5::varchar IS NULL OR target=$5)
+			AND ($6::varchar IS NULL OR channel=$6)
+		ORDER BY id DESC
+		LIMIT $2;
+	`, entryid, amount, actor, action, target, channel)
+	if err != nil {
+		return []*AuditEntry{}, err
+	}
+	defer rows.Close()
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		entry := &AuditEntry{}
+		var ch sql.NullString
+		var before, after []byte
+		err := rows.Scan(&(entry.ID), &(entry.Actor), &(entry.Action), &(entry.Target), &ch, &(entry.Reason), &before, &after, &(entry.Timestamp))
+		if err != nil {
+			return []*AuditEntry{}, err
+		}
+		entry.Channel = ch.String
+		if before != nil {
+			entry.Before = json.RawMessage(before)
+		}
+		if after != nil {
+			entry.After = json.RawMessage(after)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
 }
 
 // AddGroup - adds a group
@@ -709,26 +3294,107 @@ func (db *Database) HasFlagChID(user string, channel int, flag string) (bool, er
 		SELECT EXISTS(SELECT 1 FROM melodious.query_flags($1, (SELECT name FROM melodious.channels WHERE id=$2 LIMIT 1), '', $3, true) LIMIT 1);
 	`, user, channel, flag)
 
-	var exists bool
-	err := row.Scan(&exists)
+	var exists bool
+	err := row.Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// GetUser - gets a user's info by their ID
+func (db *Database) GetUser(id int) (*User, error) {
+	row := db.db.QueryRow(`
+		SELECT id, username, owner FROM melodious.accounts WHERE id=$1;
+	`, id)
+	user := &User{}
+	err := row.Scan(&(user.ID), &(user.Username), &(user.Owner))
+	if err != nil {
+		return &User{}, err
+	}
+	return user, nil
+}
+
+// FilterReaders - picks users who can read a channel, i.e. owners and users with perms.get-messages flag in it who are
+// not banned. All users are considered if usernames is nil
+func (db *Database) FilterReaders(channel string, usernames []string) ([]string, error) {
+	// make sure we pass NULL to PostgreSQL if all users are considered
+	var names interface{}
+	if usernames != nil {
+		names = pq.Array(usernames)
+	} else {
+		names = nil
+	}
+	rows, err := db.db.Query(`
+		SELECT a.username FROM melodious.accounts a
+		WHERE ($2::varchar[] IS NULL OR a.username = ANY($2::varchar[]))
+			AND NOT EXISTS(
+				SELECT 1 FROM melodious.bans b WHERE b.user_id=a.id AND (b.expires IS NULL OR b.expires > NOW())
+			)
+			AND (a.owner OR EXISTS(SELECT 1 FROM melodious.query_flags(a.username, $1, '', 'perms.get-messages', true)));
+	`, channel, names)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+	readers := []string{}
+	for rows.Next() {
+		var username string
+		err := rows.Scan(&username)
+		if err != nil {
+			return []string{}, err
+		}
+		readers = append(readers, username)
+	}
+	return readers, nil
+}
+
+// GetGroupMembers - gets names of users holding a group, either globally or in the given channel
+func (db *Database) GetGroupMembers(groupid int, channel string) ([]string, error) {
+	rows, err := db.db.Query(`
+		SELECT DISTINCT a.username
+		FROM melodious.group_holders gh
+		INNER JOIN melodious.accounts a ON gh.user_id = a.id
+		WHERE gh.group_id=$1
+			AND (gh.channel_id IS NULL OR gh.channel_id=(SELECT id FROM melodious.channels WHERE name=$2 LIMIT 1));
+	`, groupid, channel)
 	if err != nil {
-		return false, err
+		return []string{}, err
 	}
-
-	return exists, nil
+	defer rows.Close()
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		err := rows.Scan(&username)
+		if err != nil {
+			return []string{}, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, nil
 }
 
-// GetUser - gets a user's info by their ID
-func (db *Database) GetUser(id int) (*User, error) {
+// GetGroupHolder - gets a group holder by its id
+func (db *Database) GetGroupHolder(id int) (*GroupHolder, error) {
 	row := db.db.QueryRow(`
-		SELECT id, username, owner FROM melodious.accounts WHERE id=$1;
+	SELECT
+		gh.id,
+		(SELECT name AS group FROM melodious.groups WHERE id=gh.group_id),
+		(SELECT username AS user FROM melodious.accounts WHERE id=gh.user_id),
+		(SELECT name AS channel FROM melodious.channels WHERE id=gh.channel_id)
+	FROM melodious.group_holders gh WHERE gh.id=$1;
 	`, id)
-	user := &User{}
-	err := row.Scan(&(user.ID), &(user.Username), &(user.Owner))
+	gh := &GroupHolder{}
+	var user sql.NullString
+	var channel sql.NullString
+	err := row.Scan(&(gh.ID), &(gh.Group), &user, &channel)
 	if err != nil {
-		return &User{}, err
+		return nil, err
 	}
-	return user, nil
+	gh.User = user.String
+	gh.Channel = channel.String
+	return gh, nil
 }
 
 // GetGroupHolders - gets all group holders that exist
@@ -800,6 +3466,30 @@ func (db *Database) GetGroups() ([]*Group, error) {
 	return groups, nil
 }
 
+// GetFlag - gets a flag of a group by their names. Returns sql.ErrNoRows if the flag is not set
+func (db *Database) GetFlag(group string, name string) (*Flag, error) {
+	row := db.db.QueryRow(`
+		SELECT
+			id,
+			(SELECT name AS group FROM melodious.groups WHERE id=flags.group_id),
+			name,
+			flag
+		FROM melodious.group_flags flags
+		WHERE group_id=(SELECT id FROM melodious.groups WHERE name=$1 LIMIT 1) AND name=$2;
+	`, group, name)
+	flag := &Flag{}
+	var rawjson []byte
+	err := row.Scan(&(flag.ID), &(flag.Group), &(flag.Name), &rawjson)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(rawjson, &(flag.Flag))
+	if err != nil {
+		return nil, err
+	}
+	return flag, nil
+}
+
 // GetFlags - gets all flags from a group by its id
 func (db *Database) GetFlags(groupid int) ([]*Flag, error) {
 	rows, err := db.db.Query(`
@@ -848,7 +3538,6 @@ func NewDatabase(mel *Melodious, addr string) (*Database, error) {
 			username varchar(32) NOT NULL UNIQUE,
 			passhash varchar(64) NOT NULL,
 			owner BOOLEAN NOT NULL,
-			banned BOOLEAN NOT NULL DEFAULT false,
 			ip inet NOT NULL DEFAULT '0.0.0.0'
 		);`)
 	if err != nil {
@@ -856,6 +3545,56 @@ func NewDatabase(mel *Melodious, addr string) (*Database, error) {
 	}
 	log.Info("DB: check/create accounts table")
 
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.bans (
+			user_id int4 NOT NULL PRIMARY KEY REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			issuer_id int4 REFERENCES melodious.accounts(id) ON DELETE SET NULL,
+			reason varchar(512) NOT NULL,
+			dt timestamp with time zone NOT NULL,
+			expires timestamp with time zone
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create bans table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.ip_bans (
+			id serial NOT NULL PRIMARY KEY,
+			ip_range cidr NOT NULL,
+			issuer_id int4 REFERENCES melodious.accounts(id) ON DELETE SET NULL,
+			reason varchar(512) NOT NULL,
+			dt timestamp with time zone NOT NULL,
+			expires timestamp with time zone
+		);
+		CREATE INDEX IF NOT EXISTS ip_bans_ip_range_idx ON melodious.ip_bans USING gist (ip_range inet_ops);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create ip_bans table")
+
+	// accounts used to have a permanent banned flag; turn it into bans
+	_, err = db.Exec(`
+		DO $$
+		BEGIN
+			IF EXISTS(
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema='melodious' AND table_name='accounts' AND column_name='banned'
+			) THEN
+				INSERT INTO melodious.bans (user_id, reason, dt)
+				SELECT id, '', NOW() FROM melodious.accounts WHERE banned
+				ON CONFLICT DO NOTHING;
+				ALTER TABLE melodious.accounts DROP COLUMN banned;
+			END IF;
+		END;
+		$$;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: migrate accounts.banned column to bans table")
+
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS melodious.channels (
 			id serial NOT NULL PRIMARY KEY,
@@ -867,6 +3606,23 @@ func NewDatabase(mel *Melodious, addr string) (*Database, error) {
 	}
 	log.Info("DB: check/create channels table")
 
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.timeouts (
+			id serial NOT NULL PRIMARY KEY,
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			chan_id int4 REFERENCES melodious.channels(id) ON DELETE CASCADE,
+			issuer_id int4 REFERENCES melodious.accounts(id) ON DELETE SET NULL,
+			reason varchar(512) NOT NULL,
+			dt timestamp with time zone NOT NULL,
+			expires timestamp with time zone NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS timeouts_user_chan_idx ON melodious.timeouts (user_id, COALESCE(chan_id, 0));
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create timeouts table")
+
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS melodious.messages (
 			id serial NOT NULL PRIMARY KEY,
@@ -881,6 +3637,339 @@ func NewDatabase(mel *Melodious, addr string) (*Database, error) {
 	}
 	log.Info("DB: check/create messages table")
 
+	_, err = db.Exec(`
+		ALTER TABLE melodious.messages ADD COLUMN IF NOT EXISTS edited timestamp with time zone;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create messages.edited column")
+
+	_, err = db.Exec(`
+		ALTER TABLE melodious.messages ADD COLUMN IF NOT EXISTS reply_to int4 REFERENCES melodious.messages(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS messages_reply_to_idx ON melodious.messages (reply_to);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create messages.reply_to column")
+
+	_, err = db.Exec(`
+		ALTER TABLE melodious.messages ADD COLUMN IF NOT EXISTS expires_at timestamp with time zone;
+		CREATE INDEX IF NOT EXISTS messages_expires_at_idx ON melodious.messages (expires_at) WHERE expires_at IS NOT NULL;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create messages.expires_at column")
+
+	_, err = db.Exec(`
+		ALTER TABLE melodious.messages ADD COLUMN IF NOT EXISTS mass_ping varchar(8);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create messages.mass_ping column")
+
+	_, err = db.Exec(`
+		ALTER TABLE melodious.channels ADD COLUMN IF NOT EXISTS retention int4;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create channels.retention column")
+
+	_, err = db.Exec(`
+		ALTER TABLE melodious.channels ADD COLUMN IF NOT EXISTS slowmode int4 NOT NULL DEFAULT 0;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create channels.slowmode column")
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS messages_search_idx ON melodious.messages USING GIN (to_tsvector('simple', message));
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create messages full-text search index")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.message_edits (
+			id serial NOT NULL PRIMARY KEY,
+			message_id int4 NOT NULL REFERENCES melodious.messages(id) ON DELETE CASCADE,
+			editor_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			message varchar(2048) NOT NULL,
+			dt timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create message_edits table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.message_deletions (
+			id serial NOT NULL PRIMARY KEY,
+			message_id int4 NOT NULL,
+			chan_id int4 NOT NULL REFERENCES melodious.channels(id) ON DELETE CASCADE,
+			author_id int4 REFERENCES melodious.accounts(id) ON DELETE SET NULL,
+			deleter_id int4 REFERENCES melodious.accounts(id) ON DELETE SET NULL,
+			message varchar(2048) NOT NULL,
+			reason varchar(512) NOT NULL,
+			dt timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create message_deletions table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.reactions (
+			message_id int4 NOT NULL REFERENCES melodious.messages(id) ON DELETE CASCADE,
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			emoji varchar(32) NOT NULL,
+			dt timestamp with time zone NOT NULL DEFAULT NOW(),
+			PRIMARY KEY(message_id, user_id, emoji)
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create reactions table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.dm_conversations (
+			id serial NOT NULL PRIMARY KEY,
+			created timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create dm_conversations table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.dm_participants (
+			conv_id int4 NOT NULL REFERENCES melodious.dm_conversations(id) ON DELETE CASCADE,
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			PRIMARY KEY(conv_id, user_id)
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create dm_participants table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.dm_messages (
+			id serial NOT NULL PRIMARY KEY,
+			conv_id int4 NOT NULL REFERENCES melodious.dm_conversations(id) ON DELETE CASCADE,
+			author_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			message varchar(2048) NOT NULL,
+			dt timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create dm_messages table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.read_markers (
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			chan_id int4 NOT NULL REFERENCES melodious.channels(id) ON DELETE CASCADE,
+			message_id int4 NOT NULL,
+			dt timestamp with time zone NOT NULL,
+			PRIMARY KEY(user_id, chan_id)
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create read_markers table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.attachments (
+			id varchar(32) NOT NULL PRIMARY KEY,
+			chan_id int4 NOT NULL REFERENCES melodious.channels(id) ON DELETE CASCADE,
+			uploader_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			message_id int4 REFERENCES melodious.messages(id) ON DELETE CASCADE,
+			filename varchar(255) NOT NULL,
+			content_type varchar(255) NOT NULL,
+			size int8 NOT NULL,
+			dt timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create attachments table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.link_embeds (
+			url varchar(2048) NOT NULL PRIMARY KEY,
+			ok bool NOT NULL,
+			title varchar(512) NOT NULL,
+			description varchar(512) NOT NULL,
+			image varchar(2048) NOT NULL,
+			site_name varchar(512) NOT NULL,
+			dt timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create link_embeds table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.message_embeds (
+			message_id int4 NOT NULL REFERENCES melodious.messages(id) ON DELETE CASCADE,
+			position int4 NOT NULL,
+			url varchar(2048) NOT NULL,
+			title varchar(512) NOT NULL,
+			description varchar(512) NOT NULL,
+			image varchar(2048) NOT NULL,
+			site_name varchar(512) NOT NULL,
+			PRIMARY KEY (message_id, position)
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create message_embeds table")
+
+	// previews used to link images on third-party sites, which clients must not fetch
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.embed_images (
+			id varchar(32) NOT NULL PRIMARY KEY,
+			content_type varchar(255) NOT NULL,
+			size int8 NOT NULL,
+			dt timestamp with time zone NOT NULL
+		);
+		UPDATE melodious.link_embeds SET image='' WHERE image LIKE 'http%';
+		UPDATE melodious.message_embeds SET image='' WHERE image LIKE 'http%';
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create embed_images table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.pins (
+			message_id int4 NOT NULL PRIMARY KEY REFERENCES melodious.messages(id) ON DELETE CASCADE,
+			chan_id int4 NOT NULL REFERENCES melodious.channels(id) ON DELETE CASCADE,
+			pinner_id int4 REFERENCES melodious.accounts(id) ON DELETE SET NULL,
+			dt timestamp with time zone NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create pins table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.inbox (
+			id serial NOT NULL PRIMARY KEY,
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			message_id int4 NOT NULL REFERENCES melodious.messages(id) ON DELETE CASCADE,
+			acked bool NOT NULL,
+			dt timestamp with time zone NOT NULL,
+			UNIQUE(user_id, message_id)
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create inbox table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.notify_prefs (
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			chan_id int4 NOT NULL REFERENCES melodious.channels(id) ON DELETE CASCADE,
+			level varchar(16) NOT NULL,
+			muted bool NOT NULL,
+			PRIMARY KEY (user_id, chan_id)
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create notify_prefs table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.highlights (
+			id serial NOT NULL PRIMARY KEY,
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			pattern varchar(256) NOT NULL,
+			regex bool NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create highlights table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.scheduled (
+			id serial NOT NULL PRIMARY KEY,
+			kind varchar(16) NOT NULL,
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			chan_id int4 REFERENCES melodious.channels(id) ON DELETE CASCADE,
+			content varchar(2048) NOT NULL,
+			message_id int4 REFERENCES melodious.messages(id) ON DELETE SET NULL,
+			due timestamp with time zone NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS scheduled_due_idx ON melodious.scheduled (due);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create scheduled table")
+
+	_, err = db.Exec(`
+		ALTER TABLE melodious.scheduled ADD COLUMN IF NOT EXISTS failure varchar(256);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create scheduled.failure column")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.polls (
+			message_id int4 NOT NULL PRIMARY KEY REFERENCES melodious.messages(id) ON DELETE CASCADE,
+			question varchar(512) NOT NULL,
+			options varchar(128) [] NOT NULL,
+			multi bool NOT NULL,
+			ends timestamp with time zone NOT NULL,
+			closed bool NOT NULL
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create polls table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.poll_votes (
+			message_id int4 NOT NULL REFERENCES melodious.polls(message_id) ON DELETE CASCADE,
+			user_id int4 NOT NULL REFERENCES melodious.accounts(id) ON DELETE CASCADE,
+			option int4 NOT NULL,
+			dt timestamp with time zone NOT NULL,
+			PRIMARY KEY (message_id, user_id, option)
+		);`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create poll_votes table")
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS melodious.audit_log (
+			id serial NOT NULL PRIMARY KEY,
+			actor_id int4 REFERENCES melodious.accounts(id) ON DELETE SET NULL,
+			actor varchar(32) NOT NULL,
+			action varchar(32) NOT NULL,
+			target varchar(256) NOT NULL,
+			channel varchar(32),
+			reason varchar(512) NOT NULL,
+			before jsonb,
+			after jsonb,
+			dt timestamp with time zone NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS audit_log_action_idx ON melodious.audit_log (action);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("DB: check/create audit_log table")
+
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS melodious.groups (
 			id serial NOT NULL PRIMARY KEY,
@@ -1390,7 +4479,11 @@ func NewDatabase(mel *Melodious, addr string) (*Database, error) {
 					"storing-for":    shf,
 					"deleting-every": dhe,
 				}).Trace("deleting old messages").Stop(&err)
-				err = dbi.DeleteOldMessages(shf)
+				var deleted map[string][]int
+				var blobs []string
+				deleted, blobs, err = dbi.DeleteOldMessages(shf)
+				deleteBlobs(mel, blobs)
+				announceDeletedMessages(mel, deleted)
 			}()
 			time.Sleep(dhe)
 		}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"path/filepath"
+	"strings"
 
 	"github.com/apex/log"
 	"github.com/gorilla/mux"
@@ -21,6 +27,17 @@ func handleIndex(mel *Melodious, w http.ResponseWriter, r *http.Request) {
 
 // handleConnect - Handles clients which want to connect to Melodious
 func handleConnect(mel *Melodious, w http.ResponseWriter, r *http.Request) {
+	banned, err := mel.Database.IsIPBanned(remoteIP(r.RemoteAddr))
+	if err != nil {
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "err": err}).Error("error when checking if address is banned")
+		return
+	}
+	if banned {
+		http.Error(w, "you are banned", http.StatusForbidden)
+		return
+	}
+
 	originChecker := func(*http.Request) bool { return true }
 
 	upgrader := websocket.Upgrader{
@@ -42,6 +59,254 @@ func handleConnect(mel *Melodious, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// authenticate - checks credentials given using HTTP basic auth. Writes an error response and returns false if they are wrong
+func authenticate(mel *Melodious, w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="melodious"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return "", false
+	}
+	// requests to the HTTP API do not pass through handleConnect, so banned ranges are checked here as well
+	banned, err := mel.Database.IsIPBanned(remoteIP(r.RemoteAddr))
+	if err == nil && !banned {
+		banned, err = mel.Database.IsUserBanned(name, remoteIP(r.RemoteAddr))
+	}
+	if err != nil {
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": name, "err": err}).Error("error when checking if user is banned")
+		return "", false
+	}
+	if banned {
+		http.Error(w, "you are banned", http.StatusForbidden)
+		return "", false
+	}
+	ok, err = mel.Database.CheckUserPassword(name, pass)
+	if err != nil {
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": name, "err": err}).Error("error when checking user password")
+		return "", false
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="melodious"`)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return "", false
+	}
+	return name, true
+}
+
+// handleUpload - Handles clients which want to upload a file
+func handleUpload(mel *Melodious, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	username, ok := authenticate(mel, w, r)
+	if !ok {
+		return
+	}
+	channel := r.URL.Query().Get("channel")
+	exists, err := mel.Database.ChannelExists(channel)
+	if err != nil {
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when checking if channel exists")
+		return
+	}
+	if !exists {
+		http.Error(w, "no such channel", http.StatusNotFound)
+		return
+	}
+	has, err := mel.HasPerm(username, channel, "perms.attach-file")
+	if err != nil {
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when checking permissions")
+		return
+	}
+	if !has {
+		http.Error(w, "no permissions", http.StatusForbidden)
+		return
+	}
+	used, err := mel.Database.GetUserUploadsSize(username)
+	if err != nil {
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when getting size of user uploads")
+		return
+	}
+	limit := mel.Config.MaxUploadSize
+	if left := mel.Config.MaxUserUploadsSize - used; left < limit {
+		limit = left
+	}
+	if limit <= 0 {
+		http.Error(w, "upload quota exceeded", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// multipart overhead is not counted towards the limit, so it is enforced once more while storing the file
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64*1024)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data request", http.StatusBadRequest)
+		return
+	}
+	var part io.Reader
+	var filename string
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "malformed multipart data", http.StatusBadRequest)
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			filename = filepath.Base(p.FileName())
+			break
+		}
+	}
+	if part == nil {
+		http.Error(w, "no file part in request", http.StatusBadRequest)
+		return
+	}
+	if filename == "." || filename == string(filepath.Separator) || len(filename) > 255 {
+		http.Error(w, "invalid file name", http.StatusBadRequest)
+		return
+	}
+	// the type given by the client is not trusted; files are sniffed instead
+	buffered := bufio.NewReaderSize(part, 512)
+	head, _ := buffered.Peek(512)
+	contentType := sniffContentType(head)
+	part = buffered
+
+	id, err := NewBlobID()
+	if err != nil {
+		http.Error(w, "sorry, an internal error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when generating file id")
+		return
+	}
+	size, err := mel.Blobs.Put(id, io.LimitReader(part, limit+1))
+	if err != nil {
+		mel.Blobs.Delete(id)
+		http.Error(w, "cannot store the file", http.StatusBadRequest)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when storing a file")
+		return
+	}
+	if size > limit {
+		mel.Blobs.Delete(id)
+		http.Error(w, "file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	attachment := &Attachment{
+		ID:          id,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+	}
+	// the quota is checked once more together with adding the file, as other uploads might have finished meanwhile
+	added, err := mel.Database.AddAttachment(attachment, channel, username, mel.Config.MaxUserUploadsSize)
+	if err != nil {
+		mel.Blobs.Delete(id)
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when adding an attachment")
+		return
+	}
+	if !added {
+		mel.Blobs.Delete(id)
+		http.Error(w, "upload quota exceeded", http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// handleDownload - Handles clients which want to download a file
+func handleDownload(mel *Melodious, w http.ResponseWriter, r *http.Request) {
+	username, ok := authenticate(mel, w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !blobIDRegexp.MatchString(id) {
+		http.Error(w, "no such file", http.StatusNotFound)
+		return
+	}
+	channel, attachment, err := mel.Database.GetAttachment(id)
+	if err != nil {
+		http.Error(w, "no such file", http.StatusNotFound)
+		return
+	}
+	has, err := mel.HasPerm(username, channel, "perms.get-messages")
+	if err != nil {
+		http.Error(w, "sorry, an internal database error has occured", http.StatusInternalServerError)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when checking permissions")
+		return
+	}
+	if !has {
+		http.Error(w, "no permissions", http.StatusForbidden)
+		return
+	}
+	blob, err := mel.Blobs.Get(id)
+	if err != nil {
+		http.Error(w, "no such file", http.StatusNotFound)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "name": username, "err": err}).Error("error when opening a stored file")
+		return
+	}
+	defer blob.Close()
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", attachment.Size))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, blob)
+}
+
+// handleEmbedImage - Handles clients which want to download an image of a link preview
+func handleEmbedImage(mel *Melodious, w http.ResponseWriter, r *http.Request) {
+	_, ok := authenticate(mel, w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !blobIDRegexp.MatchString(id) {
+		http.Error(w, "no such image", http.StatusNotFound)
+		return
+	}
+	contentType, size, err := mel.Database.GetEmbedImage(id)
+	if err != nil {
+		http.Error(w, "no such image", http.StatusNotFound)
+		return
+	}
+	blob, err := mel.Blobs.Get(id)
+	if err != nil {
+		http.Error(w, "no such image", http.StatusNotFound)
+		log.WithFields(log.Fields{"addr": r.RemoteAddr, "err": err}).Error("error when opening a stored image")
+		return
+	}
+	defer blob.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, blob)
+}
+
+// safeContentTypes - types of uploads served as is; files of other types are served as application/octet-stream
+var safeContentTypes = []string{"text/plain", "application/pdf", "application/zip", "application/ogg"}
+
+// sniffContentType - detects type of an uploaded file from its first bytes. Types which browsers might render as
+// active content, such as HTML, are replaced with application/octet-stream
+func sniffContentType(head []byte) string {
+	contentType := http.DetectContentType(head)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	if strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") || contains(safeContentTypes, mediaType) {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
 // NewHTTPHandler - creates a new HTTPHandler xD
 func NewHTTPHandler(mel *Melodious) *HTTPHandler {
 	router := mux.NewRouter()
@@ -54,6 +319,9 @@ func NewHTTPHandler(mel *Melodious) *HTTPHandler {
 
 	router.HandleFunc("/", wrap(mel, handleIndex))
 	router.HandleFunc("/connect", wrap(mel, handleConnect))
+	router.HandleFunc("/attachments", wrap(mel, handleUpload))
+	router.HandleFunc("/attachments/{id}", wrap(mel, handleDownload)).Methods(http.MethodGet)
+	router.HandleFunc("/embeds/{id}", wrap(mel, handleEmbedImage)).Methods(http.MethodGet)
 
 	return &HTTPHandler{
 		Router: router,
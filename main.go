@@ -29,6 +29,8 @@ func main() {
 
 	// Setup Melodious
 	mel := NewMelodious(cfg)
+	// blobs are deleted by background jobs started when connecting to the database
+	mel.SetupBlobStore()
 	mel.ConnectToDB()
 	<-mel.RunWebServer()
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"net"
 	"net/http"
+	"regexp"
 	"sync"
+	"time"
 
 	"github.com/apex/log"
 )
@@ -12,7 +14,20 @@ import (
 type Melodious struct {
 	Config    *Config
 	Database  *Database
+	Blobs     BlobStore
+	Unfurler  *Unfurler
 	UserConns *sync.Map
+	// LastPosts - times of last posts of users to channels in slowmode by slowmodeKey
+	LastPosts *sync.Map
+	// highlights - compiled highlights of all users by username; nil until loaded from the database
+	highlights   map[string][]*regexp.Regexp
+	highlightsMu sync.Mutex
+}
+
+// slowmodeKey - identifies a user in a channel for slowmode
+type slowmodeKey struct {
+	username string
+	channel  string
 }
 
 // NewMelodious - creates a new Melodious instance
@@ -20,7 +35,10 @@ func NewMelodious(cfg *Config) *Melodious {
 	return &Melodious{
 		Config:    cfg,
 		Database:  nil,
+		Blobs:     nil,
+		Unfurler:  NewUnfurler(),
 		UserConns: &sync.Map{},
+		LastPosts: &sync.Map{},
 	}
 }
 
@@ -31,6 +49,29 @@ func (mel *Melodious) ConnectToDB() {
 	if err != nil {
 		panic(err)
 	}
+	go runScheduler(mel)
+}
+
+// SetupBlobStore - sets up storage for uploaded files
+func (mel *Melodious) SetupBlobStore() {
+	var err error
+	mel.Blobs, err = NewLocalBlobStore(mel.Config.UploadDir)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// HasPerm - checks if the given user has the given permission
+func (mel *Melodious) HasPerm(username string, channel string, flag string) (bool, error) {
+	owner, err := mel.Database.IsUserOwner(username)
+	if err != nil {
+		return false, err
+	}
+	if owner {
+		return true, nil
+	}
+	has, err := mel.Database.HasFlag(username, channel, flag)
+	return has, err
 }
 
 // webServerRunner - An internal function used by RunWebServer
@@ -83,6 +124,70 @@ func (mel *Melodious) RemoveConnection(username string, connInfo *ConnInfo) {
 	}
 }
 
+// TakeSlowmode - records a post of a user to a channel in slowmode of the given interval. Returns how long the user
+// has to wait if their previous post is too recent, in which case the post is not recorded. Otherwise also returns a
+// function which forgets the post again if it could not be made
+func (mel *Melodious) TakeSlowmode(username string, channel string, interval time.Duration) (time.Duration, func()) {
+	key := slowmodeKey{username: username, channel: channel}
+	now := time.Now()
+	for {
+		last, loaded := mel.LastPosts.LoadOrStore(key, now)
+		if !loaded {
+			return 0, func() { mel.LastPosts.CompareAndDelete(key, now) }
+		}
+		if wait := last.(time.Time).Add(interval).Sub(now); wait > 0 {
+			return wait, func() {}
+		}
+		// another post of the user might have been recorded in the meantime
+		if mel.LastPosts.CompareAndSwap(key, last, now) {
+			return 0, func() { mel.LastPosts.CompareAndSwap(key, now, last) }
+		}
+	}
+}
+
+// PruneSlowmode - forgets posts older than the given interval
+func (mel *Melodious) PruneSlowmode(interval time.Duration) {
+	mel.LastPosts.Range(func(key interface{}, value interface{}) bool {
+		if time.Since(value.(time.Time)) > interval {
+			mel.LastPosts.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// GetHighlights - gets compiled highlights of all users by username, loading them from the database if needed.
+// The returned map must not be modified
+func (mel *Melodious) GetHighlights() (map[string][]*regexp.Regexp, error) {
+	mel.highlightsMu.Lock()
+	defer mel.highlightsMu.Unlock()
+	if mel.highlights != nil {
+		return mel.highlights, nil
+	}
+	highlights, err := mel.Database.GetAllHighlights()
+	if err != nil {
+		return nil, err
+	}
+	compiled := map[string][]*regexp.Regexp{}
+	for username, hs := range highlights {
+		for _, h := range hs {
+			// highlights are validated when added, but skip ones which no longer compile anyway
+			re, err := h.Compile()
+			if err == nil {
+				compiled[username] = append(compiled[username], re)
+			}
+		}
+	}
+	mel.highlights = compiled
+	return compiled, nil
+}
+
+// InvalidateHighlights - makes the next GetHighlights call reload highlights from the database
+func (mel *Melodious) InvalidateHighlights() {
+	mel.highlightsMu.Lock()
+	mel.highlights = nil
+	mel.highlightsMu.Unlock()
+}
+
 // IterateOverConnections - iterates over all connections of a given username
 func (mel *Melodious) IterateOverConnections(username string, f func(connInfo *ConnInfo)) {
 	m, loaded := mel.UserConns.Load(username)
@@ -97,6 +202,32 @@ func (mel *Melodious) IterateOverConnections(username string, f func(connInfo *C
 	}
 }
 
+// IsOnline - checks if a user has at least one open connection
+func (mel *Melodious) IsOnline(username string) bool {
+	online := false
+	m, loaded := mel.UserConns.Load(username)
+	if !loaded {
+	} else if m := m.(*sync.Map); m != nil {
+		m.Range(func(key interface{}, value interface{}) bool {
+			online = true
+			return false
+		})
+	}
+	return online
+}
+
+// OnlineUsers - gets names of users who have at least one open connection
+func (mel *Melodious) OnlineUsers() []string {
+	usernames := []string{}
+	mel.UserConns.Range(func(uname interface{}, m interface{}) bool {
+		if mel.IsOnline(uname.(string)) {
+			usernames = append(usernames, uname.(string))
+		}
+		return true
+	})
+	return usernames
+}
+
 // IterateOverAllConnections - iterates over all connections
 func (mel *Melodious) IterateOverAllConnections(f func(connInfo *ConnInfo)) {
 	mel.UserConns.Range(func(uname interface{}, m interface{}) bool {
@@ -111,3 +242,12 @@ func (mel *Melodious) IterateOverAllConnections(f func(connInfo *ConnInfo)) {
 		return true
 	})
 }
+
+// IterateOverSubscribers - iterates over all connections subscribed to a given channel
+func (mel *Melodious) IterateOverSubscribers(channel string, f func(connInfo *ConnInfo)) {
+	mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
+		if subbed, ok := connInfo.subscriptions.Load(channel); subbed == true && ok {
+			f(connInfo)
+		}
+	})
+}
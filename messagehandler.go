@@ -2,10 +2,16 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"net"
+	"regexp"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/apex/log"
 )
@@ -18,7 +24,7 @@ func handleRegisterMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessa
 		return
 	}
 	m := message.(*MessageRegister)
-	banned, err := mel.Database.IsUserBanned(m.Name, strings.Split(connInfo.connection.RemoteAddr().String(), ":")[0])
+	banned, err := mel.Database.IsUserBanned(m.Name, remoteIP(connInfo.connection.RemoteAddr().String()))
 	if err != nil {
 		send(&MessageFail{Message: err.Error()})
 		return
@@ -50,9 +56,9 @@ func handleRegisterMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessa
 		}).Error("error when checking if database has users")
 		send(&MessageFatal{Message: "sorry, an internal database error has occured"})
 	} else if firstrun {
-		err = mel.Database.RegisterUserOwner(m.Name, m.Pass, true, strings.Split(connInfo.connection.RemoteAddr().String(), ":")[0])
+		err = mel.Database.RegisterUserOwner(m.Name, m.Pass, true, remoteIP(connInfo.connection.RemoteAddr().String()))
 	} else {
-		err = mel.Database.RegisterUser(m.Name, m.Pass, strings.Split(connInfo.connection.RemoteAddr().String(), ":")[0])
+		err = mel.Database.RegisterUser(m.Name, m.Pass, remoteIP(connInfo.connection.RemoteAddr().String()))
 	}
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -91,7 +97,7 @@ func handleLoginMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage,
 		return
 	}
 	m := message.(*MessageLogin)
-	banned, err := mel.Database.IsUserBanned(m.Name, strings.Split(connInfo.connection.RemoteAddr().String(), ":")[0])
+	banned, err := mel.Database.IsUserBanned(m.Name, remoteIP(connInfo.connection.RemoteAddr().String()))
 	if err != nil {
 		send(&MessageFail{Message: err.Error()})
 		return
@@ -119,6 +125,18 @@ func handleLoginMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage,
 				connInfo.messageStream <- event
 			}
 		})
+		count, err := mel.Database.GetInboxCount(m.Name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": m.Name,
+				"err":  err,
+			}).Error("error when counting inbox entries")
+		} else if count != 0 {
+			send(&MessageInboxCount{Count: count})
+		}
+		deliverReminders(mel, m.Name)
+		deliverFailedMessages(mel, m.Name)
 	}
 }
 
@@ -143,6 +161,7 @@ func handleNewChannelMessage(mel *Melodious, connInfo *ConnInfo, message BaseMes
 				"err":  err,
 			}).Error("error when creating a channel")
 		} else {
+			audit(mel, connInfo.username, "new-channel", cn, cn, "", nil, map[string]interface{}{"topic": ct})
 			send(&MessageOk{Message: "created a channel successfully"})
 			mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
 				connInfo.messageStream <- nc
@@ -165,7 +184,16 @@ func handleChannelTopicMessage(mel *Melodious, connInfo *ConnInfo, message BaseM
 			"err":  err,
 		}).Error("error when checking if user can change channel topic")
 	} else if can {
-		err = mel.Database.SetChannelTopic(cn, ct)
+		var before interface{}
+		chnl, err := mel.Database.GetChannel(cn)
+		if err == nil {
+			before = map[string]interface{}{"topic": chnl.Topic}
+		} else if err == sql.ErrNoRows {
+			err = nil
+		}
+		if err == nil {
+			err = mel.Database.SetChannelTopic(cn, ct)
+		}
 		if err != nil {
 			send(&MessageFail{Message: "sorry, an internal database error has occured"})
 			log.WithFields(log.Fields{
@@ -174,6 +202,7 @@ func handleChannelTopicMessage(mel *Melodious, connInfo *ConnInfo, message BaseM
 				"err":  err,
 			}).Error("error when changing channel topic")
 		} else {
+			audit(mel, connInfo.username, "channel-topic", cn, cn, "", before, map[string]interface{}{"topic": ct})
 			send(&MessageOk{Message: "changed channel topic successfully"})
 			mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
 				connInfo.messageStream <- mct
@@ -184,6 +213,152 @@ func handleChannelTopicMessage(mel *Melodious, connInfo *ConnInfo, message BaseM
 	}
 }
 
+func handleSetChannelRetentionMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageSetChannelRetention)
+	can, err := connInfo.HasPerm(procmsg.Name, "perms.manage-channels")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can change channel retention")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if procmsg.Retention < 0 {
+		send(&MessageFail{Message: "retention must not be negative"})
+		return
+	}
+	chnl, err := mel.Database.GetChannel(procmsg.Name)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such channel"})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching channel")
+		return
+	}
+	err = mel.Database.SetChannelRetention(procmsg.Name, procmsg.Retention)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when changing channel retention")
+		return
+	}
+	audit(mel, connInfo.username, "set-channel-retention", procmsg.Name, procmsg.Name, "",
+		map[string]interface{}{"retention": chnl.Retention}, map[string]interface{}{"retention": procmsg.Retention})
+	send(&MessageOk{Message: "changed channel retention successfully"})
+	event := &MessageSetChannelRetention{Name: procmsg.Name, Retention: procmsg.Retention}
+	mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+// maxSlowmode - maximum slowmode interval of a channel in seconds
+const maxSlowmode = 6 * 60 * 60
+
+func handleSetChannelSlowmodeMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageSetChannelSlowmode)
+	can, err := connInfo.HasPerm(procmsg.Name, "perms.manage-channels")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can change channel slowmode")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if procmsg.Slowmode < 0 || procmsg.Slowmode > maxSlowmode {
+		send(&MessageFail{Message: "slowmode must be 0 to " + strconv.Itoa(maxSlowmode) + " seconds"})
+		return
+	}
+	chnl, err := mel.Database.GetChannel(procmsg.Name)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such channel"})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching channel")
+		return
+	}
+	err = mel.Database.SetChannelSlowmode(procmsg.Name, procmsg.Slowmode)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when changing channel slowmode")
+		return
+	}
+	audit(mel, connInfo.username, "set-channel-slowmode", procmsg.Name, procmsg.Name, "",
+		map[string]interface{}{"slowmode": chnl.Slowmode}, map[string]interface{}{"slowmode": procmsg.Slowmode})
+	send(&MessageOk{Message: "changed channel slowmode successfully"})
+	event := &MessageSetChannelSlowmode{Name: procmsg.Name, Slowmode: procmsg.Slowmode}
+	mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+// checkSlowmode - checks that the user may post to a channel in slowmode and records the post. Sends a failure with
+// retry-after and returns false if they have to wait. The returned function forgets the post and must be called if
+// posting fails afterwards
+func checkSlowmode(mel *Melodious, connInfo *ConnInfo, channel string, send func(BaseMessage)) (func(), bool) {
+	nothing := func() {}
+	chnl, err := mel.Database.GetChannel(channel)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such channel"})
+		return nothing, false
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching channel")
+		return nothing, false
+	}
+	if chnl.Slowmode == 0 {
+		return nothing, true
+	}
+	can, err := connInfo.HasPerm(channel, "perms.bypass-slowmode")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can bypass slowmode")
+		return nothing, false
+	} else if can {
+		return nothing, true
+	}
+	wait, undo := mel.TakeSlowmode(connInfo.username, channel, time.Duration(chnl.Slowmode)*time.Second)
+	if wait > 0 {
+		send(&MessageFail{Message: "slowmode is enabled in " + channel + ", wait before posting again", RetryAfter: retryAfter(wait)})
+		return nothing, false
+	}
+	return undo, true
+}
+
 func handleDeleteChannelMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
 	cn := message.(*MessageDeleteChannel).Name
 	dc := &MessageDeleteChannel{Name: cn}
@@ -196,7 +371,17 @@ func handleDeleteChannelMessage(mel *Melodious, connInfo *ConnInfo, message Base
 			"err":  err,
 		}).Error("error when checking if user can delete a channel")
 	} else if can {
-		err = mel.Database.DeleteChannel(cn)
+		var before interface{}
+		chnl, err := mel.Database.GetChannel(cn)
+		if err == nil {
+			before = chnl
+		} else if err == sql.ErrNoRows {
+			err = nil
+		}
+		var blobs []string
+		if err == nil {
+			blobs, err = mel.Database.DeleteChannel(cn)
+		}
 		if err != nil {
 			send(&MessageFail{Message: "sorry, an internal database error has occured"})
 			log.WithFields(log.Fields{
@@ -205,6 +390,8 @@ func handleDeleteChannelMessage(mel *Melodious, connInfo *ConnInfo, message Base
 				"err":  err,
 			}).Error("error when deleting a channel")
 		} else {
+			deleteBlobs(mel, blobs)
+			audit(mel, connInfo.username, "delete-channel", cn, cn, "", before, nil)
 			send(&MessageOk{Message: "deleted a channel successfully"})
 			mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
 				connInfo.messageStream <- dc
@@ -263,6 +450,27 @@ func handleSubscribeMessage(mel *Melodious, connInfo *ConnInfo, message BaseMess
 }
 
 func handlePostMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessagePostMsg)
+	if strings.HasPrefix(procmsg.Content, "//") {
+		// a doubled slash posts the message as is, without the first slash
+		postMessage(mel, connInfo, &MessagePostMsg{Content: procmsg.Content[1:], Channel: procmsg.Channel, ReplyTo: procmsg.ReplyTo, Attachments: procmsg.Attachments, TTL: procmsg.TTL}, send)
+	} else if strings.HasPrefix(procmsg.Content, "/") {
+		ctx := &CommandContext{Channel: procmsg.Channel, ReplyTo: procmsg.ReplyTo, Attachments: procmsg.Attachments, TTL: procmsg.TTL}
+		runCommand(mel, connInfo, ctx, procmsg.Content, send)
+	} else {
+		postMessage(mel, connInfo, procmsg, send)
+	}
+}
+
+// maxMessageTTL - maximum lifetime of a message in seconds
+const maxMessageTTL = 30 * 24 * 60 * 60
+
+// postMessage - posts a message to a channel. Slash commands which post messages go through it as well, so timed out
+// users can still use the other ones
+func postMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	if !checkTimeout(mel, connInfo, message.(*MessagePostMsg).Channel, send) {
+		return
+	}
 	can, err := connInfo.HasPerm(message.(*MessagePostMsg).Channel, "perms.post-message")
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
@@ -282,27 +490,74 @@ func handlePostMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessag
 		send(&MessageFail{Message: "not subscribed to the sending channel"})
 		return
 	}
-	author := connInfo.username
-	ids := scanForPings(message.(*MessagePostMsg).Content)
-	pings := []string{}
-	unknownids := []int{}
-	for _, id := range ids {
-		user, err := mel.Database.GetUser(id)
+	replyTo := message.(*MessagePostMsg).ReplyTo
+	if replyTo != 0 {
+		channel, root, err := mel.Database.GetMessageDetails(replyTo)
 		if err == sql.ErrNoRows {
-			unknownids = append(unknownids, id)
+			send(&MessageFail{Message: "no such message with id " + strconv.Itoa(replyTo)})
+			return
 		} else if err != nil {
 			send(&MessageFail{Message: "sorry, an internal database error has occured"})
 			log.WithFields(log.Fields{
 				"addr": connInfo.connection.RemoteAddr().String(),
 				"name": connInfo.username,
 				"err":  err,
-			}).Error("error when getting user info")
-		} else {
-			pings = append(pings, user.Username)
+			}).Error("error when fetching message details")
+			return
+		} else if channel != message.(*MessagePostMsg).Channel {
+			send(&MessageFail{Message: "cannot reply to a message from another channel"})
+			return
+		}
+		// threads are flat: replying to a reply continues its thread
+		if root.ReplyTo != 0 {
+			replyTo = root.ReplyTo
+		}
+	}
+	ttl := message.(*MessagePostMsg).TTL
+	if ttl < 0 || ttl > maxMessageTTL {
+		send(&MessageFail{Message: "ttl must be 0 to " + strconv.Itoa(maxMessageTTL) + " seconds"})
+		return
+	}
+	attachments := message.(*MessagePostMsg).Attachments
+	if len(attachments) != 0 {
+		can, err := mel.Database.CanAttach(attachments, connInfo.username, message.(*MessagePostMsg).Channel)
+		if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when checking attachments")
+			return
+		}
+		if !can {
+			send(&MessageFail{Message: "invalid attachments"})
+			return
 		}
 	}
-	msg, err := mel.Database.PostMessage(message.(*MessagePostMsg).Channel, message.(*MessagePostMsg).Content, pings, author)
+	undoSlowmode, ok := checkSlowmode(mel, connInfo, message.(*MessagePostMsg).Channel, send)
+	if !ok {
+		return
+	}
+	author := connInfo.username
+	pings, massPing, warnings, err := resolvePings(mel, connInfo.username, message.(*MessagePostMsg).Channel, message.(*MessagePostMsg).Content)
 	if err != nil {
+		undoSlowmode()
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when getting user info")
+		return
+	}
+	msg, err := mel.Database.PostMessage(message.(*MessagePostMsg).Channel, message.(*MessagePostMsg).Content, pings, massPing, author, replyTo, ttl, attachments)
+	if err == ErrInvalidAttachments {
+		// the files were attached to another message after they were checked
+		undoSlowmode()
+		send(&MessageFail{Message: "invalid attachments"})
+	} else if err != nil {
+		undoSlowmode()
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
@@ -310,30 +565,316 @@ func handlePostMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessag
 			"err":  err,
 		}).Error("error when posting a message")
 	} else {
-		im := &MessagePostMsg{Channel: message.(*MessagePostMsg).Channel, MsgObj: msg}
-		if len(pings) != 0 {
-			ping := &MessagePing{Message: im.MsgObj, Channel: im.Channel}
-			for _, username := range pings {
-				mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
-					connInfo.messageStream <- ping
-				})
+		if len(attachments) != 0 {
+			err = mel.Database.FillAttachments([]*ChatMessage{msg})
+			if err != nil {
+				send(&MessageFail{Message: "sorry, an internal database error has occured"})
+				log.WithFields(log.Fields{
+					"addr": connInfo.connection.RemoteAddr().String(),
+					"name": connInfo.username,
+					"err":  err,
+				}).Error("error when fetching attachments of a message")
 			}
 		}
-		mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
-			if subbed, ok := connInfo.subscriptions.Load(message.(*MessagePostMsg).Channel); subbed == true && ok {
-				connInfo.messageStream <- im
+		broadcastMessage(mel, message.(*MessagePostMsg).Channel, msg)
+		warnPings(warnings, send)
+	}
+}
+
+// broadcastMessage - delivers a posted message to subscribers of its channel and notifies users about it
+func broadcastMessage(mel *Melodious, channel string, msg *ChatMessage) {
+	im := &MessagePostMsg{Channel: channel, MsgObj: msg}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- im
+	})
+	// resolving mass mentions might take a while, so it does not hold up the poster
+	go notifyMessage(mel, channel, msg)
+	go unfurlMessage(mel, channel, msg, false)
+}
+
+// notifyMessage - notifies users mentioned in a posted message or wanting to know about every message in its channel
+func notifyMessage(mel *Melodious, channel string, msg *ChatMessage) {
+	pings := msg.Pings
+	if msg.MassPing != "" {
+		mass, err := massPingTargets(mel, channel, msg)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"channel": channel,
+				"name":    msg.Author,
+				"err":     err,
+			}).Error("error when resolving mass mentions")
+		}
+		pings = append([]string{}, pings...)
+		for _, username := range mass {
+			if !contains(pings, username) {
+				pings = append(pings, username)
 			}
+		}
+	}
+	notified, everything, err := applyNotifyPrefs(mel, channel, msg.Author, pings)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"channel": channel,
+			"name":    msg.Author,
+			"err":     err,
+		}).Error("error when applying notification preferences")
+	}
+	if len(notified) != 0 {
+		err = mel.Database.AddToInbox(msg.ID, notified)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"channel": channel,
+				"name":    msg.Author,
+				"err":     err,
+			}).Error("error when adding mentions to inboxes")
+		}
+	}
+	mention := &MessagePing{Message: msg, Channel: channel, Reason: "mention"}
+	for _, username := range notified {
+		mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+			connInfo.messageStream <- mention
+		})
+	}
+	all := &MessagePing{Message: msg, Channel: channel, Reason: "all"}
+	for _, username := range everything {
+		mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+			connInfo.messageStream <- all
 		})
+	}
+	pinged := append(append([]string{}, notified...), everything...)
+	highlightMessage(mel, channel, msg, pinged)
+}
+
+// massPingTargets - resolves a mass mention of a message to users who can read its channel, except the author
+func massPingTargets(mel *Melodious, channel string, msg *ChatMessage) ([]string, error) {
+	var candidates []string
+	if msg.MassPing == "here" {
+		candidates = mel.OnlineUsers()
+	}
+	readers, err := mel.Database.FilterReaders(channel, candidates)
+	if err != nil {
+		return []string{}, err
+	}
+	targets := []string{}
+	for _, username := range readers {
+		if username != msg.Author {
+			targets = append(targets, username)
+		}
+	}
+	return targets, nil
+}
+
+// resolvePings - resolves mentions in message content to usernames. Mentions of everyone and of those online are
+// returned as a mass mention ("everyone" or "here") and are resolved when the message is delivered. Returns warnings
+// about mentions which were not resolved
+func resolvePings(mel *Melodious, author string, channel string, content string) ([]string, string, []string, error) {
+	pings := []string{}
+	warnings := []string{}
+	addPing := func(username string) {
+		if !contains(pings, username) {
+			pings = append(pings, username)
+		}
+	}
+
+	unknownids := []int{}
+	for _, id := range scanForPings(content) {
+		user, err := mel.Database.GetUser(id)
+		if err == sql.ErrNoRows {
+			unknownids = append(unknownids, id)
+		} else if err != nil {
+			return nil, "", nil, err
+		} else {
+			addPing(user.Username)
+		}
+	}
+	if len(unknownids) != 0 {
 		unkidstr := ""
 		for _, id := range unknownids {
 			unkidstr += strconv.Itoa(id) + " "
 		}
-		if len(unknownids) != 0 {
-			send(&MessageNote{Message: "warning: unknown ids " + unkidstr})
+		warnings = append(warnings, "unknown ids "+unkidstr)
+	}
+
+	// group mentions only reach members who can read the channel
+	groupPings := []string{}
+	groupids := scanForGroupPings(content)
+	if len(groupids) != 0 {
+		can, err := mel.HasPerm(author, channel, "perms.mention-group")
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if !can {
+			warnings = append(warnings, "no permissions to mention groups")
+		} else {
+			unkidstr := ""
+			for _, id := range groupids {
+				exists, err := mel.Database.GroupExistsID(id)
+				if err != nil {
+					return nil, "", nil, err
+				}
+				if !exists {
+					unkidstr += strconv.Itoa(id) + " "
+					continue
+				}
+				members, err := mel.Database.GetGroupMembers(id, channel)
+				if err != nil {
+					return nil, "", nil, err
+				}
+				for _, username := range members {
+					if username != author && !contains(pings, username) && !contains(groupPings, username) {
+						groupPings = append(groupPings, username)
+					}
+				}
+			}
+			if unkidstr != "" {
+				warnings = append(warnings, "unknown group ids "+unkidstr)
+			}
+		}
+	}
+	if len(groupPings) != 0 {
+		readers, err := mel.Database.FilterReaders(channel, groupPings)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		for _, username := range readers {
+			addPing(username)
+		}
+	}
+	massPing := ""
+	if scanForEveryonePing(content) {
+		can, err := mel.HasPerm(author, channel, "perms.mention-everyone")
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if can {
+			massPing = "everyone"
+		} else {
+			warnings = append(warnings, "no permissions to mention everyone")
+		}
+	}
+	if massPing == "" && scanForHerePing(content) {
+		can, err := mel.HasPerm(author, channel, "perms.mention-here")
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if can {
+			massPing = "here"
+		} else {
+			warnings = append(warnings, "no permissions to mention here")
+		}
+	}
+	return pings, massPing, warnings, nil
+}
+
+// applyNotifyPrefs - decides who gets notified about a message posted to a channel according to notification preferences.
+// Returns mentioned users who want to be notified and other users who want to be notified about every message
+func applyNotifyPrefs(mel *Melodious, channel string, author string, pings []string) ([]string, []string, error) {
+	prefs, err := mel.Database.GetChannelNotifyPrefs(channel)
+	if err != nil {
+		// notifying about mentions is better than losing them
+		return pings, []string{}, err
+	}
+	notified := []string{}
+	for _, username := range pings {
+		if p, ok := prefs[username]; ok && (p.Muted || p.Level == "none") {
+			continue
+		}
+		notified = append(notified, username)
+	}
+	candidates := []string{}
+	for username, p := range prefs {
+		if p.Muted || p.Level != "all" || username == author || contains(pings, username) {
+			continue
+		}
+		candidates = append(candidates, username)
+	}
+	if len(candidates) == 0 {
+		return notified, []string{}, nil
+	}
+	everything, err := mel.Database.FilterReaders(channel, candidates)
+	if err != nil {
+		return notified, []string{}, err
+	}
+	return notified, everything, nil
+}
+
+// highlightMessage - notifies users whose highlights match a posted message. Users who were already notified about it are skipped
+func highlightMessage(mel *Melodious, channel string, msg *ChatMessage, notified []string) {
+	highlights, err := mel.GetHighlights()
+	if err != nil {
+		log.WithFields(log.Fields{"channel": channel, "err": err}).Error("error when fetching highlights")
+		return
+	}
+	if len(highlights) == 0 {
+		return
+	}
+	prefs, err := mel.Database.GetChannelNotifyPrefs(channel)
+	if err != nil {
+		log.WithFields(log.Fields{"channel": channel, "err": err}).Error("error when fetching notification preferences")
+		return
+	}
+	event := &MessageHighlight{Message: msg, Channel: channel}
+	for username, hs := range highlights {
+		if username == msg.Author || contains(notified, username) {
+			continue
+		}
+		if p, ok := prefs[username]; ok && (p.Muted || p.Level == "none") {
+			continue
+		}
+		matched := false
+		for _, re := range hs {
+			if re.MatchString(msg.Message) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		can, err := mel.HasPerm(username, channel, "perms.get-messages")
+		if err != nil {
+			log.WithFields(log.Fields{"channel": channel, "name": username, "err": err}).Error("error when checking if user can get messages")
+			continue
+		}
+		if can {
+			mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+				connInfo.messageStream <- event
+			})
 		}
 	}
 }
 
+// audit - records a privileged action in the audit log. before and after are converted to JSON unless they are nil.
+// Errors are only logged, so that an action which has already been done is still reported as successful
+func audit(mel *Melodious, actor string, action string, target string, channel string, reason string, before interface{}, after interface{}) {
+	var b, a []byte
+	var err error
+	if before != nil {
+		b, err = json.Marshal(before)
+	}
+	if err == nil && after != nil {
+		a, err = json.Marshal(after)
+	}
+	if err == nil {
+		err = mel.Database.AddAuditEntry(actor, action, target, channel, reason, b, a)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"name":   actor,
+			"action": action,
+			"target": target,
+			"err":    err,
+		}).Error("error when writing an audit log entry")
+	}
+}
+
+// warnPings - notes the sender about mentions which were not resolved
+func warnPings(warnings []string, send func(BaseMessage)) {
+	for _, warning := range warnings {
+		send(&MessageNote{Message: "warning: " + warning})
+	}
+}
+
 func handleGetMsgsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
 	request := message.(*MessageGetMsgs)
 
@@ -352,28 +893,82 @@ func handleGetMsgsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessag
 		return
 	}
 
-	msgs, err := mel.Database.GetMessages(request.ChannelID, request.MessageID, request.Amount)
-	if err != nil {
-		send(&MessageFail{Message: "sorry, an internal database error has occured"})
-		log.WithFields(log.Fields{
+	var msgs []*ChatMessage
+	switch request.Mode {
+	case "after":
+		msgs, err = mel.Database.GetMessagesAfter(request.ChannelID, request.MessageID, request.Amount)
+	case "around":
+		// the anchor message itself is included into the newer half
+		var older []*ChatMessage
+		half := request.Amount / 2
+		msgs, err = mel.Database.GetMessagesAfter(request.ChannelID, request.MessageID-1, request.Amount-half)
+		if err == nil {
+			older, err = mel.Database.GetMessages(request.ChannelID, request.MessageID, half)
+			msgs = append(msgs, older...)
+		}
+	default:
+		msgs, err = mel.Database.GetMessages(request.ChannelID, request.MessageID, request.Amount)
+	}
+	if err == nil {
+		err = mel.Database.FillReactions(msgs, connInfo.username)
+	}
+	if err == nil {
+		err = mel.Database.FillAttachments(msgs)
+	}
+	if err == nil {
+		err = mel.Database.FillEmbeds(msgs)
+	}
+	if err == nil {
+		err = mel.Database.FillPolls(msgs, connInfo.username)
+	}
+	var hasMoreBefore, hasMoreAfter bool
+	if err == nil {
+		oldest, newest := request.MessageID, request.MessageID
+		if len(msgs) != 0 {
+			oldest, newest = msgs[len(msgs)-1].ID, msgs[0].ID
+		}
+		hasMoreBefore, hasMoreAfter, err = mel.Database.HasMoreMessages(request.ChannelID, oldest, newest)
+	}
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
 		}).Error("error when fetching messages")
 	} else {
-		send(&MessageGetMsgsResult{Messages: msgs})
+		send(&MessageGetMsgsResult{Messages: msgs, HasMoreBefore: hasMoreBefore, HasMoreAfter: hasMoreAfter})
 	}
 }
 
-func handleListChannelsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := connInfo.HasPerm("", "perms.list-channels")
+func handleGetThreadMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	request := message.(*MessageGetThread)
+
+	channel, root, err := mel.Database.GetMessageDetails(request.ID)
+	if err == nil && root.ReplyTo != 0 {
+		channel, root, err = mel.Database.GetMessageDetails(root.ReplyTo)
+	}
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(request.ID)})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return
+	}
+
+	can, err := connInfo.HasPerm(channel, "perms.get-messages")
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user can list channels")
+		}).Error("error when checking if user can get messages")
 		return
 	}
 	if !can {
@@ -381,447 +976,2238 @@ func handleListChannelsMessage(mel *Melodious, connInfo *ConnInfo, message BaseM
 		return
 	}
 
-	if message.(*MessageListChannels).HasChannels {
-		send(&MessageNote{Message: "you cannot set channels field in list-channels message"})
+	msgs, err := mel.Database.GetThread(root.ID, request.MessageID, request.Amount)
+	if err == nil {
+		err = mel.Database.FillReactions(append(msgs, root), connInfo.username)
+	}
+	if err == nil {
+		err = mel.Database.FillAttachments(append(msgs, root))
+	}
+	if err == nil {
+		err = mel.Database.FillEmbeds(append(msgs, root))
+	}
+	if err == nil {
+		err = mel.Database.FillPolls(append(msgs, root), connInfo.username)
 	}
-	channels, err := mel.Database.ListChannels()
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when listing channels")
+		}).Error("error when fetching a thread")
 	} else {
-		send(&MessageListChannels{Channels: channels, HasChannels: true})
+		send(&MessageGetThreadResult{Root: root, Channel: channel, Messages: msgs})
 	}
 }
 
-func handleListUsersMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := connInfo.HasPerm("", "perms.list-users")
+func handleSearchMsgsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	request := message.(*MessageSearchMsgs)
+
+	for _, date := range []string{request.From, request.To} {
+		if _, err := time.Parse(time.RFC3339, date); date != "" && err != nil {
+			send(&MessageFail{Message: "invalid date " + date + "; must be an ISO 8601 timestamp"})
+			return
+		}
+	}
+
+	// only search channels the user can read
+	chanids, err := mel.Database.GetReadableChannelIDs(connInfo.username, request.Channel)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user can list users")
+		}).Error("error when checking which channels user can get messages from")
 		return
 	}
-	if !can {
-		send(&MessageFail{Message: "no permissions"})
+	filter := &SearchFilter{
+		Query:      request.Query,
+		Author:     request.Author,
+		ChannelIDs: chanids,
+		From:       request.From,
+		To:         request.To,
+	}
+	if request.MentionsMe {
+		filter.Mentions = connInfo.username
+	}
+	if request.Channel != "" && len(filter.ChannelIDs) == 0 {
+		send(&MessageFail{Message: "no such channel or no permissions"})
 		return
 	}
 
-	if message.(*MessageListUsers).HasUsers {
-		send(&MessageNote{Message: "you cannot set users field in list-users message"})
-	}
-	users, err := mel.Database.GetUsersList()
+	results, err := mel.Database.SearchMessages(filter, request.MessageID, request.Amount)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when getting users list")
+		}).Error("error when searching messages")
 	} else {
-		statuses := []*UserStatus{}
-		for _, user := range users {
-			_, online := mel.UserConns.Load(user.Username)
-			statuses = append(statuses, &UserStatus{User: user, Online: online})
-		}
-		send(&MessageListUsers{Users: statuses, HasUsers: true})
+		send(&MessageSearchMsgsResult{Results: results})
 	}
 }
 
-func handleKickMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := connInfo.HasPerm("", "perms.kickban")
+func handleMarkReadMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageMarkRead)
+
+	channel, _, err := mel.Database.GetMessageDetails(procmsg.ID)
+	if err == sql.ErrNoRows || (err == nil && channel != procmsg.Channel) {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(procmsg.ID) + " in channel " + procmsg.Channel})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return
+	}
+	can, err := connInfo.HasPerm(channel, "perms.get-messages")
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user can kick and ban")
+		}).Error("error when checking if user can get messages")
 		return
 	} else if !can {
 		send(&MessageFail{Message: "no permissions"})
 		return
 	}
 
-	username := message.(*MessageKick).Username
-	if username == connInfo.username {
-		send(&MessageFail{Message: "you can't kick or ban yourself"})
+	lastRead, err := mel.Database.MarkRead(connInfo.username, channel, procmsg.ID)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when marking messages as read")
 		return
 	}
+	send(&MessageOk{Message: "marked channel " + channel + " as read up to message with id " + strconv.Itoa(lastRead)})
+	event := &MessageMarkRead{Channel: channel, ID: lastRead}
+	self := connInfo
+	mel.IterateOverConnections(connInfo.username, func(connInfo *ConnInfo) {
+		if connInfo != self {
+			connInfo.messageStream <- event
+		}
+	})
+}
 
-	exists, err := mel.Database.UserExists(username)
+func handleUnreadSummaryMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	if len(message.(*MessageUnreadSummary).Channels) != 0 {
+		send(&MessageNote{Message: "you cannot set channels field in unread-summary message"})
+	}
+	channels, err := mel.Database.ListChannels()
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if a user exists")
-		return
-	} else if !exists {
-		send(&MessageFail{Message: "no such user"})
+		}).Error("error when listing channels")
 		return
 	}
-	mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
-		connInfo.messageStream <- &MessageFatal{Message: "you've been kicked or banned"}
-	})
-	if message.(*MessageKick).Ban {
-		err = mel.Database.Ban(username)
+	chanids := []int{}
+	for _, channel := range channels {
+		can, err := connInfo.HasPerm(channel.Name, "perms.get-messages")
 		if err != nil {
 			send(&MessageFail{Message: "sorry, an internal database error has occured"})
 			log.WithFields(log.Fields{
 				"addr": connInfo.connection.RemoteAddr().String(),
 				"name": connInfo.username,
 				"err":  err,
-			}).Error("error when banning a user")
+			}).Error("error when checking if user can get messages")
 			return
+		} else if can {
+			chanids = append(chanids, channel.ID)
 		}
-		send(&MessageOk{Message: "kicked and banned user " + username})
-		mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
-			connInfo.messageStream <- message
-		})
-		return
 	}
-	send(&MessageOk{Message: "kicked user " + username})
-}
-
-func handleNewGroupMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := mel.Database.IsUserOwner(connInfo.username)
+	summaries, err := mel.Database.GetUnreadSummary(connInfo.username, chanids)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user can manage groups")
-		return
-	} else if !can {
-		send(&MessageFail{Message: "no permissions"})
+		}).Error("error when counting unread messages")
+	} else {
+		send(&MessageUnreadSummary{Channels: summaries})
+	}
+}
+
+func handleGetInboxMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageGetInbox)
+	if procmsg.Amount <= 0 || procmsg.Amount > 100 {
+		send(&MessageFail{Message: "amount must be 1 to 100"})
 		return
 	}
-	if exists, err := mel.Database.GroupExists(message.(*MessageNewGroup).Name); err != nil {
+	entries, err := mel.Database.GetInbox(connInfo.username, procmsg.EntryID, procmsg.Amount, procmsg.UnackedOnly)
+	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if a group exists")
+		}).Error("error when fetching inbox entries")
 		return
-	} else if exists {
-		send(&MessageFail{Message: "such group already exists"})
+	}
+	send(&MessageGetInboxResult{Entries: entries})
+}
+
+func handleAckInboxMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageAckInbox)
+	if procmsg.IDs == nil && !procmsg.All {
+		send(&MessageFail{Message: "nothing to acknowledge"})
 		return
 	}
-	id, err := mel.Database.AddGroup(message.(*MessageNewGroup).Name)
+	acked, err := mel.Database.AckInbox(connInfo.username, procmsg.IDs)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when adding a group")
+		}).Error("error when acknowledging inbox entries")
 		return
 	}
-	send(&MessageOk{Message: "created group " + message.(*MessageNewGroup).Name + " with id " + strconv.Itoa(id)})
-}
-
-func handleDeleteGroupMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := mel.Database.IsUserOwner(connInfo.username)
+	count, err := mel.Database.GetInboxCount(connInfo.username)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user can manage groups")
+		}).Error("error when counting inbox entries")
 		return
-	} else if !can {
-		send(&MessageFail{Message: "no permissions"})
+	}
+	send(&MessageOk{Message: "acknowledged " + strconv.Itoa(acked) + " mentions"})
+	// keeps other sessions of the user in sync
+	event := &MessageInboxCount{Count: count}
+	mel.IterateOverConnections(connInfo.username, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+// maxDMParticipants - maximum amount of users in a direct message conversation, including its author
+const maxDMParticipants = 10
+
+func handleSetNotifyPrefsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageSetNotifyPrefs)
+	if procmsg.HasLevel && procmsg.Level != "all" && procmsg.Level != "mentions" && procmsg.Level != "none" {
+		send(&MessageFail{Message: "level must be one of all, mentions, none"})
 		return
 	}
-	exists, err := mel.Database.GroupExists(message.(*MessageDeleteGroup).Name)
+	exists, err := mel.Database.ChannelExists(procmsg.Channel)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if a group exists")
+		}).Error("error when checking if channel exists")
 		return
 	} else if !exists {
-		send(&MessageFail{Message: "no such group"})
+		send(&MessageFail{Message: "no such channel"})
 		return
 	}
-	err = mel.Database.DeleteGroup(message.(*MessageDeleteGroup).Name)
+	var level, muted interface{}
+	if procmsg.HasLevel {
+		level = procmsg.Level
+	}
+	if procmsg.HasMuted {
+		muted = procmsg.Muted
+	}
+	prefs, err := mel.Database.SetNotifyPrefs(connInfo.username, procmsg.Channel, level, muted)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when deleting a group")
+		}).Error("error when setting notification preferences")
 		return
 	}
-	send(&MessageOk{Message: "deleted group " + message.(*MessageDeleteGroup).Name})
+	send(&MessageGetNotifyPrefs{Prefs: []*NotifyPrefs{prefs}})
 }
 
-func handleSetFlagMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := mel.Database.IsUserOwner(connInfo.username)
+func handleGetNotifyPrefsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	if message.(*MessageGetNotifyPrefs).Prefs != nil {
+		send(&MessageNote{Message: "you cannot set prefs field in get-notify-prefs message"})
+	}
+	prefs, err := mel.Database.GetNotifyPrefs(connInfo.username)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user is owner")
+		}).Error("error when getting notification preferences")
 		return
-	} else if !can {
-		send(&MessageFail{Message: "no permissions"})
+	}
+	send(&MessageGetNotifyPrefs{Prefs: prefs})
+}
+
+// maxHighlights - maximum amount of highlights a user can have
+const maxHighlights = 32
+
+func handleAddHighlightMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageAddHighlight)
+	if l := utf8.RuneCountInString(procmsg.Pattern); l == 0 || l > 256 {
+		send(&MessageFail{Message: "pattern must be 1 to 256 characters long"})
 		return
 	}
-	exists, err := mel.Database.GroupExists(message.(*MessageSetFlag).Group)
+	h := &Highlight{Pattern: procmsg.Pattern, Regex: procmsg.Regex}
+	if _, err := h.Compile(); err != nil {
+		send(&MessageFail{Message: "invalid regular expression: " + err.Error()})
+		return
+	}
+	highlights, err := mel.Database.GetHighlights(connInfo.username)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if a group exists")
+		}).Error("error when getting highlights")
 		return
-	} else if !exists {
-		send(&MessageFail{Message: "no such group"})
+	} else if len(highlights) >= maxHighlights {
+		send(&MessageFail{Message: "you cannot have more than " + strconv.Itoa(maxHighlights) + " highlights"})
 		return
 	}
-	procmsg := message.(*MessageSetFlag)
-	_, err = mel.Database.SetFlag(&Flag{Group: procmsg.Group, Name: procmsg.Name, Flag: procmsg.Flag})
+	id, err := mel.Database.AddHighlight(connInfo.username, procmsg.Pattern, procmsg.Regex)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when setting a flag")
+		}).Error("error when adding a highlight")
 		return
 	}
-	send(&MessageOk{Message: "set flag " + procmsg.Name + " for group " + procmsg.Group})
+	mel.InvalidateHighlights()
+	send(&MessageOk{Message: "added highlight with id " + strconv.Itoa(id)})
 }
 
-func handleDeleteFlagMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := mel.Database.IsUserOwner(connInfo.username)
+func handleDeleteHighlightMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageDeleteHighlight)
+	deleted, err := mel.Database.DeleteHighlight(connInfo.username, procmsg.ID)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user is owner")
+		}).Error("error when deleting a highlight")
 		return
-	} else if !can {
-		send(&MessageFail{Message: "no permissions"})
+	} else if !deleted {
+		send(&MessageFail{Message: "no such highlight with id " + strconv.Itoa(procmsg.ID)})
 		return
 	}
-	exists, err := mel.Database.GroupExists(message.(*MessageDeleteFlag).Group)
+	mel.InvalidateHighlights()
+	send(&MessageOk{Message: "deleted highlight with id " + strconv.Itoa(procmsg.ID)})
+}
+
+func handleGetHighlightsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	if message.(*MessageGetHighlights).Highlights != nil {
+		send(&MessageNote{Message: "you cannot set highlights field in get-highlights message"})
+	}
+	highlights, err := mel.Database.GetHighlights(connInfo.username)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if a group exists")
-		return
-	} else if !exists {
-		send(&MessageFail{Message: "no such group"})
+		}).Error("error when getting highlights")
 		return
 	}
-	procmsg := message.(*MessageDeleteFlag)
-	err = mel.Database.DeleteFlag(&Flag{Group: procmsg.Group, Name: procmsg.Name})
+	send(&MessageGetHighlights{Highlights: highlights})
+}
+
+// checkSchedule - validates content and delivery time of a scheduled item and checks if the user can schedule more items
+func checkSchedule(mel *Melodious, connInfo *ConnInfo, content string, at string, send func(BaseMessage)) (time.Time, bool) {
+	if l := utf8.RuneCountInString(content); l == 0 || l > 2048 {
+		send(&MessageFail{Message: "content must be 1 to 2048 characters long"})
+		return time.Time{}, false
+	}
+	due, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		send(&MessageFail{Message: "at must be an RFC 3339 timestamp"})
+		return time.Time{}, false
+	}
+	if !due.After(time.Now()) {
+		send(&MessageFail{Message: "at must be in the future"})
+		return time.Time{}, false
+	} else if due.After(time.Now().Add(maxScheduleAhead)) {
+		send(&MessageFail{Message: "at must be within a year from now"})
+		return time.Time{}, false
+	}
+	count, err := mel.Database.CountScheduled(connInfo.username)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when removing a flag")
-		return
+		}).Error("error when counting scheduled items")
+		return time.Time{}, false
+	} else if count >= maxScheduledItems {
+		send(&MessageFail{Message: "you cannot have more than " + strconv.Itoa(maxScheduledItems) + " scheduled items"})
+		return time.Time{}, false
 	}
-	send(&MessageOk{Message: "removed flag " + procmsg.Name + " from group " + procmsg.Group})
+	return due, true
 }
 
-func handleTypingMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := connInfo.HasPerm(message.(*MessageTyping).Channel, "perms.post-message")
+func handleScheduleMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageScheduleMsg)
+	exists, err := mel.Database.ChannelExists(procmsg.Channel)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user can type")
+		}).Error("error when checking if channel exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such channel"})
 		return
 	}
-	if !can {
+	can, err := connInfo.HasPerm(procmsg.Channel, "perms.post-message")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can post a message")
+		return
+	} else if !can {
 		send(&MessageFail{Message: "no permissions"})
 		return
 	}
-
-	if message.(*MessageTyping).HasUsername {
-		send(&MessageNote{Message: "you cannot set username field in typing message"})
+	due, ok := checkSchedule(mel, connInfo, procmsg.Content, procmsg.At, send)
+	if !ok {
+		return
 	}
-	username := connInfo.username
-	procmsg := message.(*MessageTyping)
-	mt := &MessageTyping{Channel: procmsg.Channel, Username: username, Typing: procmsg.Typing}
-	mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
-		if subbed, ok := connInfo.subscriptions.Load(message.(*MessageTyping).Channel); subbed == true && ok {
-			connInfo.messageStream <- mt
-		}
+	id, err := mel.Database.AddScheduled(&ScheduledItem{
+		Kind:    "message",
+		Author:  connInfo.username,
+		Channel: procmsg.Channel,
+		Content: procmsg.Content,
+		Due:     due.Format(time.RFC3339),
 	})
-	if procmsg.Typing {
-		send(&MessageOk{Message: "typing in " + procmsg.Channel})
-	} else {
-		send(&MessageOk{Message: "stopped typing in " + procmsg.Channel})
-	}
-}
-
-func handleNewGroupHolderMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := mel.Database.IsUserOwner(connInfo.username)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user is owner")
-		return
-	} else if !can {
-		send(&MessageFail{Message: "no permissions"})
+		}).Error("error when scheduling a message")
 		return
 	}
+	send(&MessageOk{Message: "scheduled message with id " + strconv.Itoa(id)})
+}
 
-	procmsg := message.(*MessageNewGroupHolder)
-	if procmsg.Channel != "" {
-		exists, err := mel.Database.ChannelExists(procmsg.Channel)
+func handleRemindMeMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageRemindMe)
+	if procmsg.MessageID != 0 {
+		channel, _, err := mel.Database.GetMessageDetails(procmsg.MessageID)
+		if err == sql.ErrNoRows {
+			send(&MessageFail{Message: "no such message with id " + strconv.Itoa(procmsg.MessageID)})
+			return
+		} else if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when fetching message details")
+			return
+		}
+		can, err := connInfo.HasPerm(channel, "perms.get-messages")
 		if err != nil {
 			send(&MessageFail{Message: "sorry, an internal database error has occured"})
 			log.WithFields(log.Fields{
 				"addr": connInfo.connection.RemoteAddr().String(),
 				"name": connInfo.username,
 				"err":  err,
-			}).Error("error when checking if the channel exists")
+			}).Error("error when checking if user can get messages")
 			return
-		} else if !exists {
-			send(&MessageFail{Message: "no such channel"})
+		} else if !can {
+			send(&MessageFail{Message: "no permissions"})
 			return
 		}
 	}
-	gh := &GroupHolder{Group: procmsg.Group, User: procmsg.User, Channel: procmsg.Channel}
-	_, err = mel.Database.AddGroupHolder(gh)
+	due, ok := checkSchedule(mel, connInfo, procmsg.Content, procmsg.At, send)
+	if !ok {
+		return
+	}
+	id, err := mel.Database.AddScheduled(&ScheduledItem{
+		Kind:      "reminder",
+		Author:    connInfo.username,
+		Content:   procmsg.Content,
+		MessageID: procmsg.MessageID,
+		Due:       due.Format(time.RFC3339),
+	})
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when adding a group holder")
+		}).Error("error when scheduling a reminder")
 		return
 	}
-	sendmsg := &MessageOk{Message: ""}
-	if procmsg.User != "" {
-		sendmsg.Message += "assigned user " + procmsg.User
-	} else {
-		sendmsg.Message += "assigned everyone"
+	send(&MessageOk{Message: "scheduled reminder with id " + strconv.Itoa(id)})
+}
+
+func handleListScheduledMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	if message.(*MessageListScheduled).Items != nil {
+		send(&MessageNote{Message: "you cannot set items field in list-scheduled message"})
 	}
-	sendmsg.Message += " to group " + procmsg.Group
-	if procmsg.Channel != "" {
-		sendmsg.Message += " to channel " + procmsg.Channel
+	items, err := mel.Database.GetScheduled(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when listing scheduled items")
+		return
 	}
-	send(sendmsg)
+	send(&MessageListScheduled{Items: items})
 }
 
-func handleDeleteGroupHolderMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	can, err := mel.Database.IsUserOwner(connInfo.username)
+func handleCancelScheduledMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageCancelScheduled)
+	cancelled, err := mel.Database.CancelScheduled(connInfo.username, procmsg.ID)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when checking if user is owner")
+		}).Error("error when cancelling a scheduled item")
 		return
-	} else if !can {
+	} else if !cancelled {
+		send(&MessageFail{Message: "no such scheduled item with id " + strconv.Itoa(procmsg.ID)})
+		return
+	}
+	send(&MessageOk{Message: "cancelled scheduled item with id " + strconv.Itoa(procmsg.ID)})
+}
+
+func handlePostDMMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessagePostDM)
+
+	can, err := connInfo.HasPerm("", "perms.direct-message")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can send direct messages")
+		return
+	}
+	if !can {
 		send(&MessageFail{Message: "no permissions"})
 		return
 	}
-	procmsg := message.(*MessageDeleteGroupHolder)
-	if exists, err := mel.Database.GroupHolderExists(procmsg.ID); err != nil {
+	// only server-wide timeouts apply to direct messages
+	if !checkTimeout(mel, connInfo, "", send) {
+		return
+	}
+
+	conv := procmsg.Conversation
+	var participants []string
+	if conv != 0 {
+		participants, err = mel.Database.GetDMParticipants(conv)
+		if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when getting conversation participants")
+			return
+		} else if !contains(participants, connInfo.username) {
+			send(&MessageFail{Message: "no such conversation with id " + strconv.Itoa(conv)})
+			return
+		}
+	} else {
+		participants = []string{connInfo.username}
+		for _, username := range procmsg.Users {
+			if contains(participants, username) {
+				continue
+			}
+			exists, err := mel.Database.UserExists(username)
+			if err != nil {
+				send(&MessageFail{Message: "sorry, an internal database error has occured"})
+				log.WithFields(log.Fields{
+					"addr": connInfo.connection.RemoteAddr().String(),
+					"name": connInfo.username,
+					"err":  err,
+				}).Error("error when checking if a user exists")
+				return
+			} else if !exists {
+				send(&MessageFail{Message: "no such user " + username})
+				return
+			}
+			participants = append(participants, username)
+		}
+		if len(participants) < 2 {
+			send(&MessageFail{Message: "you can't send direct messages to yourself only"})
+			return
+		} else if len(participants) > maxDMParticipants {
+			send(&MessageFail{Message: "too many users; maximum is " + strconv.Itoa(maxDMParticipants)})
+			return
+		}
+		conv, err = mel.Database.GetDMConversation(participants)
+		if err == sql.ErrNoRows {
+			conv, err = mel.Database.NewDMConversation(participants)
+		}
+		if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when getting a conversation")
+			return
+		}
+		sort.Strings(participants)
+	}
+
+	msg, err := mel.Database.PostDM(conv, procmsg.Content, connInfo.username)
+	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when deleting a group holder")
+		}).Error("error when posting a direct message")
 		return
-	} else if !exists {
-		send(&MessageFail{Message: "a group holder with such id does not exist"})
+	}
+	event := &MessagePostDM{Conversation: conv, Users: participants, MsgObj: msg}
+	for _, username := range participants {
+		mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+			connInfo.messageStream <- event
+		})
+	}
+}
+
+func handleGetDMHistoryMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	request := message.(*MessageGetDMHistory)
+
+	participants, err := mel.Database.GetDMParticipants(request.Conversation)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when getting conversation participants")
+		return
+	} else if !contains(participants, connInfo.username) {
+		send(&MessageFail{Message: "no such conversation with id " + strconv.Itoa(request.Conversation)})
 		return
 	}
-	err = mel.Database.DeleteGroupHolder(procmsg.ID)
+
+	msgs, err := mel.Database.GetDMHistory(request.Conversation, request.MessageID, request.Amount)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when deleting a group holder")
+		}).Error("error when fetching direct messages")
+	} else {
+		send(&MessageGetDMHistoryResult{Conversation: request.Conversation, Messages: msgs})
+	}
+}
+
+func handleListDMsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	if len(message.(*MessageListDMs).Conversations) != 0 {
+		send(&MessageNote{Message: "you cannot set conversations field in list-dms message"})
+	}
+	convs, err := mel.Database.ListDMConversations(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when listing conversations")
+	} else {
+		send(&MessageListDMs{Conversations: convs})
+	}
+}
+
+func handleListChannelsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.list-channels")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can list channels")
 		return
 	}
-	send(&MessageOk{Message: "deleted group holder with id " + strconv.Itoa(procmsg.ID)})
+	if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+
+	if message.(*MessageListChannels).HasChannels {
+		send(&MessageNote{Message: "you cannot set channels field in list-channels message"})
+	}
+	channels, err := mel.Database.ListChannels()
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when listing channels")
+	} else {
+		send(&MessageListChannels{Channels: channels, HasChannels: true})
+	}
 }
 
-func handleDeleteMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
-	procmsg := message.(*MessageDeleteMsg)
-	channel, msg, err := mel.Database.GetMessageDetails(procmsg.ID)
-	if err == sql.ErrNoRows {
-		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(procmsg.ID)})
+func handleListUsersMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.list-users")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can list users")
 		return
-	} else if err != nil {
+	}
+	if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+
+	if message.(*MessageListUsers).HasUsers {
+		send(&MessageNote{Message: "you cannot set users field in list-users message"})
+	}
+	users, err := mel.Database.GetUsersList()
+	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when fetching message details")
+		}).Error("error when getting users list")
+	} else {
+		statuses := []*UserStatus{}
+		for _, user := range users {
+			_, online := mel.UserConns.Load(user.Username)
+			statuses = append(statuses, &UserStatus{User: user, Online: online})
+		}
+		send(&MessageListUsers{Users: statuses, HasUsers: true})
+	}
+}
+
+// maxBanDuration - maximum duration of a temporary ban in seconds
+const maxBanDuration = 10 * 365 * 24 * 60 * 60
+
+func handleKickMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.kickban")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can kick and ban")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
 		return
 	}
-	if msg.Author != connInfo.username {
-		can, err := connInfo.HasPerm(channel, "perms.delete-message")
+
+	procmsg := message.(*MessageKick)
+	username := procmsg.Username
+	if username == connInfo.username {
+		send(&MessageFail{Message: "you can't kick or ban yourself"})
+		return
+	}
+	if utf8.RuneCountInString(procmsg.Reason) > 512 {
+		send(&MessageFail{Message: "reason must be at most 512 characters long"})
+		return
+	}
+	if procmsg.Duration < 0 || procmsg.Duration > maxBanDuration {
+		send(&MessageFail{Message: "duration must be from 0 to " + strconv.Itoa(maxBanDuration) + " seconds"})
+		return
+	} else if procmsg.Duration != 0 && !procmsg.Ban {
+		send(&MessageFail{Message: "duration can only be set for bans"})
+		return
+	}
+
+	exists, err := mel.Database.UserExists(username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if a user exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such user"})
+		return
+	}
+	note := "you've been kicked or banned"
+	if procmsg.Reason != "" {
+		note += ": " + procmsg.Reason
+	}
+	mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- &MessageFatal{Message: note}
+	})
+	if procmsg.Ban {
+		err = mel.Database.Ban(username, connInfo.username, procmsg.Reason, procmsg.Duration)
 		if err != nil {
 			send(&MessageFail{Message: "sorry, an internal database error has occured"})
 			log.WithFields(log.Fields{
 				"addr": connInfo.connection.RemoteAddr().String(),
 				"name": connInfo.username,
 				"err":  err,
-			}).Error("error when checking if user has permissions")
-			return
-		} else if !can {
-			send(&MessageFail{Message: "no permissions"})
+			}).Error("error when banning a user")
 			return
 		}
+		audit(mel, connInfo.username, "ban", username, "", procmsg.Reason, nil, map[string]interface{}{"duration": procmsg.Duration})
+		send(&MessageOk{Message: "kicked and banned user " + username})
+		mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
+			connInfo.messageStream <- message
+		})
+		return
+	}
+	audit(mel, connInfo.username, "kick", username, "", procmsg.Reason, nil, nil)
+	send(&MessageOk{Message: "kicked user " + username})
+}
+
+func handleUnbanMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.kickban")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can kick and ban")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	username := message.(*MessageUnban).Username
+	ban, err := mel.Database.GetBan(username)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "user " + username + " is not banned"})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching a ban")
+		return
 	}
-	err = mel.Database.DeleteMessage(procmsg.ID)
+	unbanned, err := mel.Database.Unban(username)
 	if err != nil {
 		send(&MessageFail{Message: "sorry, an internal database error has occured"})
 		log.WithFields(log.Fields{
 			"addr": connInfo.connection.RemoteAddr().String(),
 			"name": connInfo.username,
 			"err":  err,
-		}).Error("error when deleting a message")
+		}).Error("error when unbanning a user")
+		return
+	} else if !unbanned {
+		// the ban has expired in the meantime
+		send(&MessageFail{Message: "user " + username + " is not banned"})
 		return
 	}
-	send(&MessageOk{Message: "deleted message with id " + strconv.Itoa(procmsg.ID)})
+	audit(mel, connInfo.username, "unban", username, "", "", ban, nil)
+	send(&MessageOk{Message: "unbanned user " + username})
+	event := &MessageUnban{Username: username}
+	mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+// maxTimeoutDuration - maximum duration of a timeout in seconds
+const maxTimeoutDuration = 28 * 24 * 60 * 60
+
+func handleTimeoutMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageTimeout)
+	can, err := connInfo.HasPerm(procmsg.Channel, "perms.timeout")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can time out")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+
+	username := procmsg.Username
+	if username == connInfo.username {
+		send(&MessageFail{Message: "you can't time out yourself"})
+		return
+	}
+	if utf8.RuneCountInString(procmsg.Reason) > 512 {
+		send(&MessageFail{Message: "reason must be at most 512 characters long"})
+		return
+	}
+	if procmsg.Duration < 0 || procmsg.Duration > maxTimeoutDuration {
+		send(&MessageFail{Message: "duration must be from 0 to " + strconv.Itoa(maxTimeoutDuration) + " seconds"})
+		return
+	}
+	exists, err := mel.Database.UserExists(username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if a user exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such user"})
+		return
+	}
+	where := "server-wide"
+	if procmsg.Channel != "" {
+		exists, err := mel.Database.ChannelExists(procmsg.Channel)
+		if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when checking if a channel exists")
+			return
+		} else if !exists {
+			send(&MessageFail{Message: "no such channel"})
+			return
+		}
+		where = "in " + procmsg.Channel
+	}
+
+	if procmsg.Duration == 0 {
+		timeout, err := mel.Database.RemoveTimeout(username, procmsg.Channel)
+		if err == sql.ErrNoRows {
+			send(&MessageFail{Message: "user " + username + " is not timed out " + where})
+			return
+		} else if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when lifting a timeout")
+			return
+		}
+		audit(mel, connInfo.username, "lift-timeout", username, procmsg.Channel, "", timeout, nil)
+		send(&MessageOk{Message: "lifted timeout of user " + username + " " + where})
+		note := &MessageNote{Message: "your timeout " + where + " has been lifted"}
+		mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+			connInfo.messageStream <- note
+		})
+		return
+	}
+
+	timeout, err := mel.Database.Timeout(username, procmsg.Channel, connInfo.username, procmsg.Reason, procmsg.Duration)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when timing out a user")
+		return
+	}
+	audit(mel, connInfo.username, "timeout", username, procmsg.Channel, procmsg.Reason, nil, map[string]interface{}{"duration": procmsg.Duration})
+	send(&MessageOk{Message: "timed out user " + username + " " + where + " until " + timeout.Expires})
+	text := "you've been timed out " + where + " until " + timeout.Expires
+	if procmsg.Reason != "" {
+		text += ": " + procmsg.Reason
+	}
+	note := &MessageNote{Message: text}
+	mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- note
+	})
+}
+
+// checkTimeout - checks that the user is not timed out in a channel. Sends a failure and returns false if they are
+func checkTimeout(mel *Melodious, connInfo *ConnInfo, channel string, send func(BaseMessage)) bool {
+	timeout, err := mel.Database.GetTimeout(connInfo.username, channel)
+	if err == sql.ErrNoRows {
+		return true
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user is timed out")
+		return false
+	}
+	send(&MessageFail{Message: "you are timed out until " + timeout.Expires})
+	return false
+}
+
+func handleListBansMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.kickban")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can kick and ban")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if message.(*MessageListBans).Bans != nil {
+		send(&MessageNote{Message: "you cannot set bans field in list-bans message"})
+	}
+	bans, err := mel.Database.ListBans()
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when listing bans")
+		return
+	}
+	send(&MessageListBans{Bans: bans})
+}
+
+func handleAddIPBanMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.kickban")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can kick and ban")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	procmsg := message.(*MessageAddIPBan)
+	ipnet, err := parseIPRange(procmsg.Range)
+	if err != nil {
+		send(&MessageFail{Message: "range must be an IP address or a CIDR range"})
+		return
+	}
+	if ipnet.Contains(net.ParseIP(remoteIP(connInfo.connection.RemoteAddr().String()))) {
+		send(&MessageFail{Message: "you can't ban a range containing your own address"})
+		return
+	}
+	if utf8.RuneCountInString(procmsg.Reason) > 512 {
+		send(&MessageFail{Message: "reason must be at most 512 characters long"})
+		return
+	}
+	if procmsg.Duration < 0 || procmsg.Duration > maxBanDuration {
+		send(&MessageFail{Message: "duration must be from 0 to " + strconv.Itoa(maxBanDuration) + " seconds"})
+		return
+	}
+	id, err := mel.Database.AddIPBan(ipnet.String(), connInfo.username, procmsg.Reason, procmsg.Duration)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when banning an address range")
+		return
+	}
+	audit(mel, connInfo.username, "add-ip-ban", ipnet.String(), "", procmsg.Reason, nil, map[string]interface{}{"id": id, "duration": procmsg.Duration})
+	send(&MessageOk{Message: "banned range " + ipnet.String() + " with id " + strconv.Itoa(id)})
+	note := "you've been banned"
+	if procmsg.Reason != "" {
+		note += ": " + procmsg.Reason
+	}
+	// users already connected from the range are disconnected
+	mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
+		if ipnet.Contains(net.ParseIP(remoteIP(connInfo.connection.RemoteAddr().String()))) {
+			connInfo.messageStream <- &MessageFatal{Message: note}
+		}
+	})
+}
+
+func handleDeleteIPBanMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.kickban")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can kick and ban")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	id := message.(*MessageDeleteIPBan).ID
+	ban, err := mel.Database.DeleteIPBan(id)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such IP ban with id " + strconv.Itoa(id)})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when lifting an IP ban")
+		return
+	}
+	audit(mel, connInfo.username, "delete-ip-ban", ban.Range, "", "", ban, nil)
+	send(&MessageOk{Message: "lifted IP ban with id " + strconv.Itoa(id)})
+}
+
+func handleListIPBansMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm("", "perms.kickban")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can kick and ban")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if message.(*MessageListIPBans).Bans != nil {
+		send(&MessageNote{Message: "you cannot set bans field in list-ip-bans message"})
+	}
+	bans, err := mel.Database.ListIPBans()
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when listing IP bans")
+		return
+	}
+	send(&MessageListIPBans{Bans: bans})
+}
+
+func handleGetAuditLogMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageGetAuditLog)
+	can, err := connInfo.HasPerm("", "perms.view-audit-log")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can view audit log")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if procmsg.Amount <= 0 || procmsg.Amount > 100 {
+		send(&MessageFail{Message: "amount must be 1 to 100"})
+		return
+	}
+	filter := &AuditFilter{Actor: procmsg.Actor, Action: procmsg.Action, Target: procmsg.Target, Channel: procmsg.Channel}
+	entries, err := mel.Database.GetAuditLog(filter, procmsg.EntryID, procmsg.Amount)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching audit log entries")
+		return
+	}
+	send(&MessageGetAuditLogResult{Entries: entries})
+}
+
+func handleNewGroupMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := mel.Database.IsUserOwner(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can manage groups")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if exists, err := mel.Database.GroupExists(message.(*MessageNewGroup).Name); err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if a group exists")
+		return
+	} else if exists {
+		send(&MessageFail{Message: "such group already exists"})
+		return
+	}
+	id, err := mel.Database.AddGroup(message.(*MessageNewGroup).Name)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when adding a group")
+		return
+	}
+	audit(mel, connInfo.username, "new-group", message.(*MessageNewGroup).Name, "", "", nil, map[string]interface{}{"id": id})
+	send(&MessageOk{Message: "created group " + message.(*MessageNewGroup).Name + " with id " + strconv.Itoa(id)})
+}
+
+func handleDeleteGroupMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := mel.Database.IsUserOwner(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can manage groups")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	exists, err := mel.Database.GroupExists(message.(*MessageDeleteGroup).Name)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if a group exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such group"})
+		return
+	}
+	err = mel.Database.DeleteGroup(message.(*MessageDeleteGroup).Name)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when deleting a group")
+		return
+	}
+	audit(mel, connInfo.username, "delete-group", message.(*MessageDeleteGroup).Name, "", "", nil, nil)
+	send(&MessageOk{Message: "deleted group " + message.(*MessageDeleteGroup).Name})
+}
+
+func handleSetFlagMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := mel.Database.IsUserOwner(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user is owner")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	exists, err := mel.Database.GroupExists(message.(*MessageSetFlag).Group)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if a group exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such group"})
+		return
+	}
+	procmsg := message.(*MessageSetFlag)
+	var before interface{}
+	old, err := mel.Database.GetFlag(procmsg.Group, procmsg.Name)
+	if err == nil {
+		before = old.Flag
+	} else if err != sql.ErrNoRows {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching a flag")
+		return
+	}
+	_, err = mel.Database.SetFlag(&Flag{Group: procmsg.Group, Name: procmsg.Name, Flag: procmsg.Flag})
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when setting a flag")
+		return
+	}
+	audit(mel, connInfo.username, "set-flag", procmsg.Group+"/"+procmsg.Name, "", "", before, procmsg.Flag)
+	send(&MessageOk{Message: "set flag " + procmsg.Name + " for group " + procmsg.Group})
+}
+
+func handleDeleteFlagMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := mel.Database.IsUserOwner(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user is owner")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	exists, err := mel.Database.GroupExists(message.(*MessageDeleteFlag).Group)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if a group exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such group"})
+		return
+	}
+	procmsg := message.(*MessageDeleteFlag)
+	var before interface{}
+	old, err := mel.Database.GetFlag(procmsg.Group, procmsg.Name)
+	if err == nil {
+		before = old.Flag
+	} else if err != sql.ErrNoRows {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching a flag")
+		return
+	}
+	err = mel.Database.DeleteFlag(&Flag{Group: procmsg.Group, Name: procmsg.Name})
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when removing a flag")
+		return
+	}
+	audit(mel, connInfo.username, "delete-flag", procmsg.Group+"/"+procmsg.Name, "", "", before, nil)
+	send(&MessageOk{Message: "removed flag " + procmsg.Name + " from group " + procmsg.Group})
+}
+
+func handleTypingMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := connInfo.HasPerm(message.(*MessageTyping).Channel, "perms.post-message")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can type")
+		return
+	}
+	if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	// typing events of timed out users are dropped silently
+	_, err = mel.Database.GetTimeout(connInfo.username, message.(*MessageTyping).Channel)
+	if err == nil {
+		return
+	} else if err != sql.ErrNoRows {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user is timed out")
+		return
+	}
+
+	if message.(*MessageTyping).HasUsername {
+		send(&MessageNote{Message: "you cannot set username field in typing message"})
+	}
+	username := connInfo.username
+	procmsg := message.(*MessageTyping)
+	mt := &MessageTyping{Channel: procmsg.Channel, Username: username, Typing: procmsg.Typing}
+	mel.IterateOverAllConnections(func(connInfo *ConnInfo) {
+		if subbed, ok := connInfo.subscriptions.Load(message.(*MessageTyping).Channel); subbed == true && ok {
+			connInfo.messageStream <- mt
+		}
+	})
+	if procmsg.Typing {
+		send(&MessageOk{Message: "typing in " + procmsg.Channel})
+	} else {
+		send(&MessageOk{Message: "stopped typing in " + procmsg.Channel})
+	}
+}
+
+func handleNewGroupHolderMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := mel.Database.IsUserOwner(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user is owner")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+
+	procmsg := message.(*MessageNewGroupHolder)
+	if procmsg.Channel != "" {
+		exists, err := mel.Database.ChannelExists(procmsg.Channel)
+		if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when checking if the channel exists")
+			return
+		} else if !exists {
+			send(&MessageFail{Message: "no such channel"})
+			return
+		}
+	}
+	gh := &GroupHolder{Group: procmsg.Group, User: procmsg.User, Channel: procmsg.Channel}
+	gh.ID, err = mel.Database.AddGroupHolder(gh)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when adding a group holder")
+		return
+	}
+	audit(mel, connInfo.username, "new-group-holder", procmsg.Group, procmsg.Channel, "", nil, gh)
+	sendmsg := &MessageOk{Message: ""}
+	if procmsg.User != "" {
+		sendmsg.Message += "assigned user " + procmsg.User
+	} else {
+		sendmsg.Message += "assigned everyone"
+	}
+	sendmsg.Message += " to group " + procmsg.Group
+	if procmsg.Channel != "" {
+		sendmsg.Message += " to channel " + procmsg.Channel
+	}
+	send(sendmsg)
+}
+
+func handleDeleteGroupHolderMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	can, err := mel.Database.IsUserOwner(connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user is owner")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	procmsg := message.(*MessageDeleteGroupHolder)
+	gh, err := mel.Database.GetGroupHolder(procmsg.ID)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "a group holder with such id does not exist"})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when deleting a group holder")
+		return
+	}
+	err = mel.Database.DeleteGroupHolder(procmsg.ID)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when deleting a group holder")
+		return
+	}
+	audit(mel, connInfo.username, "delete-group-holder", gh.Group, gh.Channel, "", gh, nil)
+	send(&MessageOk{Message: "deleted group holder with id " + strconv.Itoa(procmsg.ID)})
+}
+
+func handleDeleteMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageDeleteMsg)
+	if utf8.RuneCountInString(procmsg.Reason) > 512 {
+		send(&MessageFail{Message: "reason must be at most 512 characters long"})
+		return
+	}
+	channel, msg, err := mel.Database.GetMessageDetails(procmsg.ID)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(procmsg.ID)})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return
+	}
+	var blobs []string
+	if msg.Author != connInfo.username {
+		var can bool
+		can, err = connInfo.HasPerm(channel, "perms.delete-message")
+		if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when checking if user has permissions")
+			return
+		} else if !can {
+			send(&MessageFail{Message: "no permissions"})
+			return
+		}
+		blobs, err = mel.Database.ModDeleteMessage(procmsg.ID, connInfo.username, procmsg.Reason)
+	} else {
+		blobs, err = mel.Database.DeleteMessage(procmsg.ID)
+	}
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when deleting a message")
+		return
+	}
+	deleteBlobs(mel, blobs)
+	if msg.Author != connInfo.username {
+		audit(mel, connInfo.username, "delete-message", strconv.Itoa(procmsg.ID), channel, procmsg.Reason,
+			map[string]interface{}{"author": msg.Author, "content": msg.Message}, nil)
+	}
+	send(&MessageOk{Message: "deleted message with id " + strconv.Itoa(procmsg.ID)})
+	event := &MessageMsgDeleted{ID: procmsg.ID, Channel: channel}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+// maxPurgeMessages - maximum amount of messages deleted by one purge-messages message
+const maxPurgeMessages = 1000
+
+func handlePurgeMsgsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessagePurgeMsgs)
+	exists, err := mel.Database.ChannelExists(procmsg.Channel)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if channel exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such channel"})
+		return
+	}
+	can, err := connInfo.HasPerm(procmsg.Channel, "perms.delete-message")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user has permissions")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if procmsg.Author == "" && procmsg.After == "" && procmsg.Before == "" && procmsg.Last == 0 && procmsg.Pattern == "" {
+		send(&MessageFail{Message: "at least one filter must be given"})
+		return
+	}
+	if procmsg.Last < 0 || procmsg.Last > maxPurgeMessages {
+		send(&MessageFail{Message: "last must be 0 to " + strconv.Itoa(maxPurgeMessages)})
+		return
+	}
+	if utf8.RuneCountInString(procmsg.Reason) > 512 {
+		send(&MessageFail{Message: "reason must be at most 512 characters long"})
+		return
+	}
+	filter := &PurgeFilter{Author: procmsg.Author, Last: procmsg.Last}
+	if procmsg.After != "" {
+		after, err := time.Parse(time.RFC3339, procmsg.After)
+		if err != nil {
+			send(&MessageFail{Message: "after must be an RFC 3339 timestamp"})
+			return
+		}
+		filter.After = after.Format(time.RFC3339)
+	}
+	if procmsg.Before != "" {
+		before, err := time.Parse(time.RFC3339, procmsg.Before)
+		if err != nil {
+			send(&MessageFail{Message: "before must be an RFC 3339 timestamp"})
+			return
+		}
+		filter.Before = before.Format(time.RFC3339)
+	}
+	if procmsg.Pattern != "" {
+		if len(procmsg.Pattern) > 256 {
+			send(&MessageFail{Message: "pattern must be at most 256 bytes long"})
+			return
+		}
+		filter.Pattern, err = regexp.Compile(procmsg.Pattern)
+		if err != nil {
+			send(&MessageFail{Message: "invalid pattern: " + err.Error()})
+			return
+		}
+	}
+	ids, blobs, err := mel.Database.PurgeMessages(procmsg.Channel, filter, maxPurgeMessages, connInfo.username, procmsg.Reason)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when purging messages")
+		return
+	}
+	deleteBlobs(mel, blobs)
+	audit(mel, connInfo.username, "purge-messages", procmsg.Channel, procmsg.Channel, procmsg.Reason, map[string]interface{}{
+		"ids":     ids,
+		"author":  procmsg.Author,
+		"after":   procmsg.After,
+		"before":  procmsg.Before,
+		"last":    procmsg.Last,
+		"pattern": procmsg.Pattern,
+	}, nil)
+	send(&MessageOk{Message: "purged " + strconv.Itoa(len(ids)) + " messages"})
+	if len(ids) == 0 {
+		return
+	}
+	event := &MessageMsgsPurged{IDs: ids, Channel: procmsg.Channel}
+	mel.IterateOverSubscribers(procmsg.Channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+func handleEditMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageEditMsg)
+	channel, msg, err := mel.Database.GetMessageDetails(procmsg.ID)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(procmsg.ID)})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return
+	}
+	if msg.Author != connInfo.username {
+		can, err := connInfo.HasPerm(channel, "perms.edit-message")
+		if err != nil {
+			send(&MessageFail{Message: "sorry, an internal database error has occured"})
+			log.WithFields(log.Fields{
+				"addr": connInfo.connection.RemoteAddr().String(),
+				"name": connInfo.username,
+				"err":  err,
+			}).Error("error when checking if user has permissions")
+			return
+		} else if !can {
+			send(&MessageFail{Message: "no permissions"})
+			return
+		}
+	}
+	if !checkTimeout(mel, connInfo, channel, send) {
+		return
+	}
+	pings, massPing, warnings, err := resolvePings(mel, connInfo.username, channel, procmsg.Content)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when getting user info")
+		return
+	}
+	edited, err := mel.Database.EditMessage(procmsg.ID, procmsg.Content, pings, massPing, connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when editing a message")
+		return
+	}
+	send(&MessageOk{Message: "edited message with id " + strconv.Itoa(procmsg.ID)})
+	warnPings(warnings, send)
+	event := &MessageMsgEdited{Message: edited, Channel: channel}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+	go unfurlMessage(mel, channel, edited, true)
+}
+
+func handleGetMsgEditsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageGetMsgEdits)
+	channel, _, err := mel.Database.GetMessageDetails(procmsg.ID)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(procmsg.ID)})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return
+	}
+	can, err := connInfo.HasPerm(channel, "perms.get-messages")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can get messages")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	edits, err := mel.Database.GetMessageEdits(procmsg.ID)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message edits")
+		return
+	}
+	send(&MessageGetMsgEdits{ID: procmsg.ID, Edits: edits})
+}
+
+// checkReaction - validates a reaction and checks if the user can react to the message. Returns the message's channel
+func checkReaction(mel *Melodious, connInfo *ConnInfo, id int, emoji string, send func(BaseMessage)) (string, bool) {
+	if l := utf8.RuneCountInString(emoji); l == 0 || l > 32 {
+		send(&MessageFail{Message: "emoji must be 1 to 32 characters long"})
+		return "", false
+	}
+	channel, _, err := mel.Database.GetMessageDetails(id)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(id)})
+		return "", false
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return "", false
+	}
+	can, err := connInfo.HasPerm(channel, "perms.react")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can react")
+		return "", false
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return "", false
+	}
+	if !checkTimeout(mel, connInfo, channel, send) {
+		return "", false
+	}
+	return channel, true
+}
+
+func handleAddReactionMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageAddReaction)
+	channel, ok := checkReaction(mel, connInfo, procmsg.ID, procmsg.Emoji, send)
+	if !ok {
+		return
+	}
+	added, err := mel.Database.AddReaction(procmsg.ID, connInfo.username, procmsg.Emoji)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when adding a reaction")
+		return
+	} else if !added {
+		send(&MessageFail{Message: "you have already reacted with " + procmsg.Emoji})
+		return
+	}
+	send(&MessageOk{Message: "reacted to message with id " + strconv.Itoa(procmsg.ID)})
+	event := &MessageReactionAdded{ID: procmsg.ID, Emoji: procmsg.Emoji, Username: connInfo.username, Channel: channel}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+func handleRemoveReactionMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageRemoveReaction)
+	channel, ok := checkReaction(mel, connInfo, procmsg.ID, procmsg.Emoji, send)
+	if !ok {
+		return
+	}
+	removed, err := mel.Database.RemoveReaction(procmsg.ID, connInfo.username, procmsg.Emoji)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when removing a reaction")
+		return
+	} else if !removed {
+		send(&MessageFail{Message: "you have not reacted with " + procmsg.Emoji})
+		return
+	}
+	send(&MessageOk{Message: "removed reaction from message with id " + strconv.Itoa(procmsg.ID)})
+	event := &MessageReactionRemoved{ID: procmsg.ID, Emoji: procmsg.Emoji, Username: connInfo.username, Channel: channel}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+// checkPin - checks if the user can (un)pin the message. Returns the message's channel and the message itself
+func checkPin(mel *Melodious, connInfo *ConnInfo, id int, send func(BaseMessage)) (string, *ChatMessage, bool) {
+	channel, msg, err := mel.Database.GetMessageDetails(id)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(id)})
+		return "", nil, false
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return "", nil, false
+	}
+	can, err := connInfo.HasPerm(channel, "perms.pin-message")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can pin messages")
+		return "", nil, false
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return "", nil, false
+	}
+	return channel, msg, true
+}
+
+func handlePinMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessagePinMsg)
+	channel, msg, ok := checkPin(mel, connInfo, procmsg.ID, send)
+	if !ok {
+		return
+	}
+	pinned, err := mel.Database.PinMessage(procmsg.ID, connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when pinning a message")
+		return
+	} else if !pinned {
+		send(&MessageFail{Message: "message with id " + strconv.Itoa(procmsg.ID) + " is already pinned"})
+		return
+	}
+	audit(mel, connInfo.username, "pin-message", strconv.Itoa(procmsg.ID), channel, "", nil, nil)
+	send(&MessageOk{Message: "pinned message with id " + strconv.Itoa(procmsg.ID)})
+	event := &MessageMsgPinned{Message: msg, Channel: channel, Username: connInfo.username}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+func handleUnpinMsgMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageUnpinMsg)
+	channel, _, ok := checkPin(mel, connInfo, procmsg.ID, send)
+	if !ok {
+		return
+	}
+	unpinned, err := mel.Database.UnpinMessage(procmsg.ID)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when unpinning a message")
+		return
+	} else if !unpinned {
+		send(&MessageFail{Message: "message with id " + strconv.Itoa(procmsg.ID) + " is not pinned"})
+		return
+	}
+	audit(mel, connInfo.username, "unpin-message", strconv.Itoa(procmsg.ID), channel, "", nil, nil)
+	send(&MessageOk{Message: "unpinned message with id " + strconv.Itoa(procmsg.ID)})
+	event := &MessageMsgUnpinned{ID: procmsg.ID, Channel: channel, Username: connInfo.username}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+func handleGetPinsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageGetPins)
+	exists, err := mel.Database.ChannelExists(procmsg.Channel)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if channel exists")
+		return
+	} else if !exists {
+		send(&MessageFail{Message: "no such channel"})
+		return
+	}
+	can, err := connInfo.HasPerm(procmsg.Channel, "perms.get-messages")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can get messages")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	msgs, err := mel.Database.GetPins(procmsg.Channel)
+	if err == nil {
+		err = mel.Database.FillReactions(msgs, connInfo.username)
+	}
+	if err == nil {
+		err = mel.Database.FillAttachments(msgs)
+	}
+	if err == nil {
+		err = mel.Database.FillEmbeds(msgs)
+	}
+	if err == nil {
+		err = mel.Database.FillPolls(msgs, connInfo.username)
+	}
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching pinned messages")
+		return
+	}
+	send(&MessageGetPins{Channel: procmsg.Channel, Messages: msgs})
+}
+
+const (
+	// maxPollOptions - maximum amount of options in a poll
+	maxPollOptions = 10
+	// maxPollDuration - how long a poll can stay open
+	maxPollDuration = 30 * 24 * time.Hour
+)
+
+func handleCreatePollMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageCreatePoll)
+	can, err := connInfo.HasPerm(procmsg.Channel, "perms.post-message")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can post a message")
+		return
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return
+	}
+	if !checkTimeout(mel, connInfo, procmsg.Channel, send) {
+		return
+	}
+	if _, ok := connInfo.subscriptions.Load(procmsg.Channel); !ok {
+		send(&MessageFail{Message: "not subscribed to the sending channel"})
+		return
+	}
+	if l := utf8.RuneCountInString(procmsg.Question); l == 0 || l > 512 {
+		send(&MessageFail{Message: "question must be 1 to 512 characters long"})
+		return
+	}
+	if len(procmsg.Options) < 2 || len(procmsg.Options) > maxPollOptions {
+		send(&MessageFail{Message: "a poll must have 2 to " + strconv.Itoa(maxPollOptions) + " options"})
+		return
+	}
+	options := []*PollOption{}
+	texts := []string{}
+	for _, option := range procmsg.Options {
+		option = strings.TrimSpace(option)
+		if l := utf8.RuneCountInString(option); l == 0 || l > 128 {
+			send(&MessageFail{Message: "options must be 1 to 128 characters long"})
+			return
+		} else if contains(texts, option) {
+			send(&MessageFail{Message: "options must be unique"})
+			return
+		}
+		texts = append(texts, option)
+		options = append(options, &PollOption{Text: option})
+	}
+	ends, err := time.Parse(time.RFC3339, procmsg.Ends)
+	if err != nil {
+		send(&MessageFail{Message: "ends must be an RFC 3339 timestamp"})
+		return
+	}
+	if !ends.After(time.Now()) {
+		send(&MessageFail{Message: "ends must be in the future"})
+		return
+	} else if ends.After(time.Now().Add(maxPollDuration)) {
+		send(&MessageFail{Message: "ends must be within 30 days from now"})
+		return
+	}
+	undoSlowmode, ok := checkSlowmode(mel, connInfo, procmsg.Channel, send)
+	if !ok {
+		return
+	}
+	msg, err := mel.Database.CreatePoll(procmsg.Channel, connInfo.username, &Poll{
+		Question: procmsg.Question,
+		Options:  options,
+		Multi:    procmsg.Multi,
+		Ends:     ends.Format(time.RFC3339),
+	})
+	if err != nil {
+		undoSlowmode()
+	} else {
+		err = mel.Database.FillPolls([]*ChatMessage{msg}, connInfo.username)
+	}
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when creating a poll")
+		return
+	}
+	broadcastMessage(mel, procmsg.Channel, msg)
+}
+
+// checkVote - checks if the user can vote in the poll. Returns the poll's channel and the poll itself
+func checkVote(mel *Melodious, connInfo *ConnInfo, id int, send func(BaseMessage)) (string, *Poll, bool) {
+	channel, msg, err := mel.Database.GetMessageDetails(id)
+	if err == sql.ErrNoRows {
+		send(&MessageFail{Message: "no such message with id " + strconv.Itoa(id)})
+		return "", nil, false
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching message details")
+		return "", nil, false
+	}
+	can, err := connInfo.HasPerm(channel, "perms.vote")
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when checking if user can vote")
+		return "", nil, false
+	} else if !can {
+		send(&MessageFail{Message: "no permissions"})
+		return "", nil, false
+	}
+	err = mel.Database.FillPolls([]*ChatMessage{msg}, connInfo.username)
+	if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when fetching a poll")
+		return "", nil, false
+	} else if msg.Poll == nil {
+		send(&MessageFail{Message: "message with id " + strconv.Itoa(id) + " is not a poll"})
+		return "", nil, false
+	} else if msg.Poll.Closed {
+		send(&MessageFail{Message: "poll with id " + strconv.Itoa(id) + " is closed"})
+		return "", nil, false
+	}
+	return channel, msg.Poll, true
+}
+
+func handleVoteMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageVote)
+	channel, poll, ok := checkVote(mel, connInfo, procmsg.ID, send)
+	if !ok {
+		return
+	}
+	if len(procmsg.Options) == 0 {
+		send(&MessageFail{Message: "no options given; use retract-vote to retract your votes"})
+		return
+	} else if !poll.Multi && len(procmsg.Options) != 1 {
+		send(&MessageFail{Message: "you can vote for only one option in this poll"})
+		return
+	}
+	seen := map[int]bool{}
+	for _, option := range procmsg.Options {
+		if option < 0 || option >= len(poll.Options) {
+			send(&MessageFail{Message: "no such option " + strconv.Itoa(option)})
+			return
+		} else if seen[option] {
+			send(&MessageFail{Message: "options must be unique"})
+			return
+		}
+		seen[option] = true
+	}
+	err := mel.Database.SetPollVotes(procmsg.ID, connInfo.username, procmsg.Options)
+	if err == ErrPollClosed {
+		send(&MessageFail{Message: "poll with id " + strconv.Itoa(procmsg.ID) + " is closed"})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when voting in a poll")
+		return
+	}
+	send(&MessageOk{Message: "voted in poll with id " + strconv.Itoa(procmsg.ID)})
+	broadcastPoll(mel, channel, procmsg.ID)
+}
+
+func handleRetractVoteMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	procmsg := message.(*MessageRetractVote)
+	channel, poll, ok := checkVote(mel, connInfo, procmsg.ID, send)
+	if !ok {
+		return
+	}
+	if len(poll.MyVotes) == 0 {
+		send(&MessageFail{Message: "you have not voted in poll with id " + strconv.Itoa(procmsg.ID)})
+		return
+	}
+	err := mel.Database.SetPollVotes(procmsg.ID, connInfo.username, []int{})
+	if err == ErrPollClosed {
+		send(&MessageFail{Message: "poll with id " + strconv.Itoa(procmsg.ID) + " is closed"})
+		return
+	} else if err != nil {
+		send(&MessageFail{Message: "sorry, an internal database error has occured"})
+		log.WithFields(log.Fields{
+			"addr": connInfo.connection.RemoteAddr().String(),
+			"name": connInfo.username,
+			"err":  err,
+		}).Error("error when retracting votes")
+		return
+	}
+	send(&MessageOk{Message: "retracted votes in poll with id " + strconv.Itoa(procmsg.ID)})
+	broadcastPoll(mel, channel, procmsg.ID)
+}
+
+// broadcastPoll - sends current results of a poll to subscribers of its channel
+func broadcastPoll(mel *Melodious, channel string, id int) {
+	msg := &ChatMessage{ID: id}
+	err := mel.Database.FillPolls([]*ChatMessage{msg}, "")
+	if err != nil {
+		log.WithFields(log.Fields{"id": id, "err": err}).Error("error when fetching poll results")
+		return
+	} else if msg.Poll == nil {
+		return
+	}
+	event := &MessagePollUpdated{ID: id, Channel: channel, Poll: msg.Poll}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
+
+func handleListCommandsMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
+	if message.(*MessageListCommands).Commands != nil {
+		send(&MessageNote{Message: "you cannot set commands field in list-commands message"})
+	}
+	send(&MessageListCommands{Commands: ListCommands()})
 }
 
 func handleGetGroupHoldersMessage(mel *Melodious, connInfo *ConnInfo, message BaseMessage, send func(BaseMessage)) {
@@ -932,6 +3318,10 @@ func messageHandler(mel *Melodious, connInfo *ConnInfo, message BaseMessage, sen
 			handleNewChannelMessage(mel, connInfo, message, send)
 		case *MessageChannelTopic:
 			handleChannelTopicMessage(mel, connInfo, message, send)
+		case *MessageSetChannelRetention:
+			handleSetChannelRetentionMessage(mel, connInfo, message, send)
+		case *MessageSetChannelSlowmode:
+			handleSetChannelSlowmodeMessage(mel, connInfo, message, send)
 		case *MessageDeleteChannel:
 			handleDeleteChannelMessage(mel, connInfo, message, send)
 		case *MessageQuit:
@@ -942,12 +3332,38 @@ func messageHandler(mel *Melodious, connInfo *ConnInfo, message BaseMessage, sen
 			handlePostMsgMessage(mel, connInfo, message, send)
 		case *MessageGetMsgs:
 			handleGetMsgsMessage(mel, connInfo, message, send)
+		case *MessageGetThread:
+			handleGetThreadMessage(mel, connInfo, message, send)
+		case *MessageSearchMsgs:
+			handleSearchMsgsMessage(mel, connInfo, message, send)
+		case *MessageMarkRead:
+			handleMarkReadMessage(mel, connInfo, message, send)
+		case *MessageUnreadSummary:
+			handleUnreadSummaryMessage(mel, connInfo, message, send)
+		case *MessagePostDM:
+			handlePostDMMessage(mel, connInfo, message, send)
+		case *MessageGetDMHistory:
+			handleGetDMHistoryMessage(mel, connInfo, message, send)
+		case *MessageListDMs:
+			handleListDMsMessage(mel, connInfo, message, send)
 		case *MessageListChannels:
 			handleListChannelsMessage(mel, connInfo, message, send)
 		case *MessageListUsers:
 			handleListUsersMessage(mel, connInfo, message, send)
 		case *MessageKick:
 			handleKickMessage(mel, connInfo, message, send)
+		case *MessageUnban:
+			handleUnbanMessage(mel, connInfo, message, send)
+		case *MessageListBans:
+			handleListBansMessage(mel, connInfo, message, send)
+		case *MessageTimeout:
+			handleTimeoutMessage(mel, connInfo, message, send)
+		case *MessageAddIPBan:
+			handleAddIPBanMessage(mel, connInfo, message, send)
+		case *MessageDeleteIPBan:
+			handleDeleteIPBanMessage(mel, connInfo, message, send)
+		case *MessageListIPBans:
+			handleListIPBansMessage(mel, connInfo, message, send)
 		case *MessageNewGroup:
 			handleNewGroupMessage(mel, connInfo, message, send)
 		case *MessageDeleteGroup:
@@ -964,6 +3380,54 @@ func messageHandler(mel *Melodious, connInfo *ConnInfo, message BaseMessage, sen
 			handleDeleteGroupHolderMessage(mel, connInfo, message, send)
 		case *MessageDeleteMsg:
 			handleDeleteMsgMessage(mel, connInfo, message, send)
+		case *MessagePurgeMsgs:
+			handlePurgeMsgsMessage(mel, connInfo, message, send)
+		case *MessageEditMsg:
+			handleEditMsgMessage(mel, connInfo, message, send)
+		case *MessageGetMsgEdits:
+			handleGetMsgEditsMessage(mel, connInfo, message, send)
+		case *MessageAddReaction:
+			handleAddReactionMessage(mel, connInfo, message, send)
+		case *MessageRemoveReaction:
+			handleRemoveReactionMessage(mel, connInfo, message, send)
+		case *MessageSetNotifyPrefs:
+			handleSetNotifyPrefsMessage(mel, connInfo, message, send)
+		case *MessageGetNotifyPrefs:
+			handleGetNotifyPrefsMessage(mel, connInfo, message, send)
+		case *MessageAddHighlight:
+			handleAddHighlightMessage(mel, connInfo, message, send)
+		case *MessageDeleteHighlight:
+			handleDeleteHighlightMessage(mel, connInfo, message, send)
+		case *MessageGetHighlights:
+			handleGetHighlightsMessage(mel, connInfo, message, send)
+		case *MessageScheduleMsg:
+			handleScheduleMsgMessage(mel, connInfo, message, send)
+		case *MessageRemindMe:
+			handleRemindMeMessage(mel, connInfo, message, send)
+		case *MessageListScheduled:
+			handleListScheduledMessage(mel, connInfo, message, send)
+		case *MessageCancelScheduled:
+			handleCancelScheduledMessage(mel, connInfo, message, send)
+		case *MessageGetInbox:
+			handleGetInboxMessage(mel, connInfo, message, send)
+		case *MessageGetAuditLog:
+			handleGetAuditLogMessage(mel, connInfo, message, send)
+		case *MessageAckInbox:
+			handleAckInboxMessage(mel, connInfo, message, send)
+		case *MessagePinMsg:
+			handlePinMsgMessage(mel, connInfo, message, send)
+		case *MessageUnpinMsg:
+			handleUnpinMsgMessage(mel, connInfo, message, send)
+		case *MessageGetPins:
+			handleGetPinsMessage(mel, connInfo, message, send)
+		case *MessageCreatePoll:
+			handleCreatePollMessage(mel, connInfo, message, send)
+		case *MessageVote:
+			handleVoteMessage(mel, connInfo, message, send)
+		case *MessageRetractVote:
+			handleRetractVoteMessage(mel, connInfo, message, send)
+		case *MessageListCommands:
+			handleListCommandsMessage(mel, connInfo, message, send)
 		case *MessageGetGroupHolders:
 			handleGetGroupHoldersMessage(mel, connInfo, message, send)
 		case *MessageGetGroups:
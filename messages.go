@@ -107,6 +107,8 @@ func (m *MessageOk) GetData() *MessageData {
 type MessageFail struct {
 	md      *MessageData
 	Message string
+	// RetryAfter - in how many seconds the operation can be retried; 0 if retrying does not help
+	RetryAfter int
 }
 
 // GetData - gets MessageData.
@@ -191,6 +193,36 @@ func (m *MessageChannelTopic) GetData() *MessageData {
 	return m.md
 }
 
+// MessageSetChannelRetention - changes for how long messages are stored in a channel
+type MessageSetChannelRetention struct {
+	md        *MessageData
+	Name      string
+	Retention int
+}
+
+// GetData - gets MessageData.
+func (m *MessageSetChannelRetention) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageSetChannelSlowmode - changes the minimum interval between posts of a user in a channel
+type MessageSetChannelSlowmode struct {
+	md       *MessageData
+	Name     string
+	Slowmode int
+}
+
+// GetData - gets MessageData.
+func (m *MessageSetChannelSlowmode) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
 // MessageSubscribe - subscribes to a channel
 type MessageSubscribe struct {
 	md   *MessageData
@@ -209,10 +241,13 @@ func (m *MessageSubscribe) GetData() *MessageData {
 
 // MessagePostMsg - sends a message to a channel
 type MessagePostMsg struct {
-	md      *MessageData
-	Content string
-	Channel string
-	MsgObj  *ChatMessage
+	md          *MessageData
+	Content     string
+	Channel     string
+	ReplyTo     int
+	Attachments []string
+	TTL         int
+	MsgObj      *ChatMessage
 }
 
 // GetData - gets MessageData.
@@ -229,6 +264,7 @@ type MessageGetMsgs struct {
 	ChannelID int
 	MessageID int
 	Amount    int
+	Mode      string
 }
 
 // GetData - gets MessageData.
@@ -241,12 +277,81 @@ func (m *MessageGetMsgs) GetData() *MessageData {
 
 // MessageGetMsgsResult - sends fetched messages
 type MessageGetMsgsResult struct {
+	md            *MessageData
+	Messages      []*ChatMessage
+	HasMoreBefore bool
+	HasMoreAfter  bool
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetMsgsResult) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetThread - gets replies to a message from the server
+type MessageGetThread struct {
+	md        *MessageData
+	ID        int
+	MessageID int
+	Amount    int
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetThread) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetThreadResult - sends a fetched thread
+type MessageGetThreadResult struct {
 	md       *MessageData
+	Root     *ChatMessage
+	Channel  string
 	Messages []*ChatMessage
 }
 
 // GetData - gets MessageData.
-func (m *MessageGetMsgsResult) GetData() *MessageData {
+func (m *MessageGetThreadResult) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageSearchMsgs - searches messages on the server
+type MessageSearchMsgs struct {
+	md         *MessageData
+	Query      string
+	Author     string
+	Channel    string
+	From       string
+	To         string
+	MentionsMe bool
+	MessageID  int
+	Amount     int
+}
+
+// GetData - gets MessageData.
+func (m *MessageSearchMsgs) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageSearchMsgsResult - sends found messages
+type MessageSearchMsgsResult struct {
+	md      *MessageData
+	Results []*SearchResult
+}
+
+// GetData - gets MessageData.
+func (m *MessageSearchMsgsResult) GetData() *MessageData {
 	if m.md == nil {
 		m.md = &MessageData{}
 	}
@@ -303,6 +408,8 @@ type MessageKick struct {
 	ID       int
 	Username string
 	Ban      bool
+	Reason   string
+	Duration int
 }
 
 // GetData - gets MessageData.
@@ -313,6 +420,95 @@ func (m *MessageKick) GetData() *MessageData {
 	return m.md
 }
 
+// MessageUnban - lifts a ban of a user
+type MessageUnban struct {
+	md       *MessageData
+	Username string
+}
+
+// GetData - gets MessageData.
+func (m *MessageUnban) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageListBans - lists active bans
+type MessageListBans struct {
+	md   *MessageData
+	Bans []*Ban
+}
+
+// GetData - gets MessageData.
+func (m *MessageListBans) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageTimeout - stops a user from posting, reacting and typing for some time
+type MessageTimeout struct {
+	md       *MessageData
+	Username string
+	Channel  string
+	Duration int
+	Reason   string
+}
+
+// GetData - gets MessageData.
+func (m *MessageTimeout) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageAddIPBan - bans an IP address range
+type MessageAddIPBan struct {
+	md       *MessageData
+	Range    string
+	Reason   string
+	Duration int
+}
+
+// GetData - gets MessageData.
+func (m *MessageAddIPBan) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageDeleteIPBan - lifts a ban of an IP address range by ID
+type MessageDeleteIPBan struct {
+	md *MessageData
+	ID int
+}
+
+// GetData - gets MessageData.
+func (m *MessageDeleteIPBan) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageListIPBans - lists active bans of IP address ranges
+type MessageListIPBans struct {
+	md   *MessageData
+	Bans []*IPBan
+}
+
+// GetData - gets MessageData.
+func (m *MessageListIPBans) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
 // MessageNewGroup - creates a group.
 type MessageNewGroup struct {
 	md   *MessageData
@@ -438,6 +634,7 @@ type MessagePing struct {
 	md      *MessageData
 	Message *ChatMessage
 	Channel string
+	Reason  string
 }
 
 // GetData - gets MessageData.
@@ -450,8 +647,9 @@ func (m *MessagePing) GetData() *MessageData {
 
 //MessageDeleteMsg - deletes a message by ID.
 type MessageDeleteMsg struct {
-	md *MessageData
-	ID int
+	md     *MessageData
+	ID     int
+	Reason string
 }
 
 // GetData - gets MessageData.
@@ -462,29 +660,703 @@ func (m *MessageDeleteMsg) GetData() *MessageData {
 	return m.md
 }
 
-// MessageGetGroups - gets a list of groups.
-type MessageGetGroups struct {
-	md     *MessageData
-	Groups []*Group
+// MessageMsgDeleted - informs subscribers about a deleted message.
+type MessageMsgDeleted struct {
+	md      *MessageData
+	ID      int
+	Channel string
+}
+
+// GetData - gets MessageData.
+func (m *MessageMsgDeleted) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessagePurgeMsgs - deletes messages of a channel matching given filters.
+type MessagePurgeMsgs struct {
+	md      *MessageData
+	Channel string
+	Author  string
+	After   string
+	Before  string
+	Last    int
+	Pattern string
+	Reason  string
+}
+
+// GetData - gets MessageData.
+func (m *MessagePurgeMsgs) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageMsgsPurged - informs subscribers about purged messages.
+type MessageMsgsPurged struct {
+	md      *MessageData
+	IDs     []int
+	Channel string
+}
+
+// GetData - gets MessageData.
+func (m *MessageMsgsPurged) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageMsgEmbeds - informs subscribers about fetched link previews of a message.
+type MessageMsgEmbeds struct {
+	md      *MessageData
+	ID      int
+	Channel string
+	Embeds  []*Embed
+}
+
+// GetData - gets MessageData.
+func (m *MessageMsgEmbeds) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageEditMsg - edits a message by ID.
+type MessageEditMsg struct {
+	md      *MessageData
+	ID      int
+	Content string
+}
+
+// GetData - gets MessageData.
+func (m *MessageEditMsg) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageMsgEdited - informs subscribers about an edited message.
+type MessageMsgEdited struct {
+	md      *MessageData
+	Message *ChatMessage
+	Channel string
+}
+
+// GetData - gets MessageData.
+func (m *MessageMsgEdited) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetMsgEdits - gets revision history of a message by its ID.
+type MessageGetMsgEdits struct {
+	md    *MessageData
+	ID    int
+	Edits []*MessageEdit
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetMsgEdits) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageAddReaction - adds a reaction to a message.
+type MessageAddReaction struct {
+	md    *MessageData
+	ID    int
+	Emoji string
+}
+
+// GetData - gets MessageData.
+func (m *MessageAddReaction) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageRemoveReaction - removes a reaction from a message.
+type MessageRemoveReaction struct {
+	md    *MessageData
+	ID    int
+	Emoji string
+}
+
+// GetData - gets MessageData.
+func (m *MessageRemoveReaction) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageReactionAdded - informs subscribers about an added reaction.
+type MessageReactionAdded struct {
+	md       *MessageData
+	ID       int
+	Emoji    string
+	Username string
+	Channel  string
+}
+
+// GetData - gets MessageData.
+func (m *MessageReactionAdded) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageReactionRemoved - informs subscribers about a removed reaction.
+type MessageReactionRemoved struct {
+	md       *MessageData
+	ID       int
+	Emoji    string
+	Username string
+	Channel  string
+}
+
+// GetData - gets MessageData.
+func (m *MessageReactionRemoved) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessagePinMsg - pins a message by ID.
+type MessagePinMsg struct {
+	md *MessageData
+	ID int
+}
+
+// GetData - gets MessageData.
+func (m *MessagePinMsg) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageUnpinMsg - unpins a message by ID.
+type MessageUnpinMsg struct {
+	md *MessageData
+	ID int
+}
+
+// GetData - gets MessageData.
+func (m *MessageUnpinMsg) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageMsgPinned - informs subscribers about a pinned message.
+type MessageMsgPinned struct {
+	md       *MessageData
+	Message  *ChatMessage
+	Channel  string
+	Username string
+}
+
+// GetData - gets MessageData.
+func (m *MessageMsgPinned) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageMsgUnpinned - informs subscribers about an unpinned message.
+type MessageMsgUnpinned struct {
+	md       *MessageData
+	ID       int
+	Channel  string
+	Username string
+}
+
+// GetData - gets MessageData.
+func (m *MessageMsgUnpinned) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetPins - gets pinned messages of a channel.
+type MessageGetPins struct {
+	md       *MessageData
+	Channel  string
+	Messages []*ChatMessage
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetPins) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetInbox - gets mentions of the user.
+type MessageGetInbox struct {
+	md          *MessageData
+	EntryID     int
+	Amount      int
+	UnackedOnly bool
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetInbox) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetInboxResult - contains mentions of the user.
+type MessageGetInboxResult struct {
+	md      *MessageData
+	Entries []*InboxEntry
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetInboxResult) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetAuditLog - gets entries of the audit log.
+type MessageGetAuditLog struct {
+	md      *MessageData
+	EntryID int
+	Amount  int
+	Actor   string
+	Action  string
+	Target  string
+	Channel string
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetAuditLog) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetAuditLogResult - contains entries of the audit log.
+type MessageGetAuditLogResult struct {
+	md      *MessageData
+	Entries []*AuditEntry
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetAuditLogResult) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageAckInbox - acknowledges mentions of the user.
+type MessageAckInbox struct {
+	md  *MessageData
+	IDs []int
+	All bool
+}
+
+// GetData - gets MessageData.
+func (m *MessageAckInbox) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageInboxCount - informs the user about amount of unacknowledged mentions.
+type MessageInboxCount struct {
+	md    *MessageData
+	Count int
+}
+
+// GetData - gets MessageData.
+func (m *MessageInboxCount) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageSetNotifyPrefs - sets notification preferences of the user in a channel.
+type MessageSetNotifyPrefs struct {
+	md       *MessageData
+	Channel  string
+	Level    string
+	HasLevel bool
+	Muted    bool
+	HasMuted bool
+}
+
+// GetData - gets MessageData.
+func (m *MessageSetNotifyPrefs) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetNotifyPrefs - gets notification preferences of the user.
+type MessageGetNotifyPrefs struct {
+	md    *MessageData
+	Prefs []*NotifyPrefs
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetNotifyPrefs) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageAddHighlight - adds a highlight of the user.
+type MessageAddHighlight struct {
+	md      *MessageData
+	Pattern string
+	Regex   bool
+}
+
+// GetData - gets MessageData.
+func (m *MessageAddHighlight) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageDeleteHighlight - deletes a highlight of the user by ID.
+type MessageDeleteHighlight struct {
+	md *MessageData
+	ID int
+}
+
+// GetData - gets MessageData.
+func (m *MessageDeleteHighlight) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetHighlights - gets highlights of the user.
+type MessageGetHighlights struct {
+	md         *MessageData
+	Highlights []*Highlight
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetHighlights) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageHighlight - notifies a user about a message matching one of their highlights.
+type MessageHighlight struct {
+	md      *MessageData
+	Message *ChatMessage
+	Channel string
+}
+
+// GetData - gets MessageData.
+func (m *MessageHighlight) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageScheduleMsg - schedules a message to a channel.
+type MessageScheduleMsg struct {
+	md      *MessageData
+	Channel string
+	Content string
+	At      string
+}
+
+// GetData - gets MessageData.
+func (m *MessageScheduleMsg) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageRemindMe - schedules a reminder for the user.
+type MessageRemindMe struct {
+	md        *MessageData
+	Content   string
+	At        string
+	MessageID int
+}
+
+// GetData - gets MessageData.
+func (m *MessageRemindMe) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageListScheduled - lists pending scheduled items of the user.
+type MessageListScheduled struct {
+	md    *MessageData
+	Items []*ScheduledItem
+}
+
+// GetData - gets MessageData.
+func (m *MessageListScheduled) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageCancelScheduled - cancels a pending scheduled item by ID.
+type MessageCancelScheduled struct {
+	md *MessageData
+	ID int
+}
+
+// GetData - gets MessageData.
+func (m *MessageCancelScheduled) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageReminder - delivers a reminder to the user.
+type MessageReminder struct {
+	md       *MessageData
+	Reminder *ScheduledItem
+}
+
+// GetData - gets MessageData.
+func (m *MessageReminder) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageListCommands - lists slash commands available on the server.
+type MessageListCommands struct {
+	md       *MessageData
+	Commands []*Command
+}
+
+// GetData - gets MessageData.
+func (m *MessageListCommands) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageCreatePoll - posts a poll to a channel.
+type MessageCreatePoll struct {
+	md       *MessageData
+	Channel  string
+	Question string
+	Options  []string
+	Ends     string
+	Multi    bool
+}
+
+// GetData - gets MessageData.
+func (m *MessageCreatePoll) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageVote - votes in a poll, replacing previous votes of the user.
+type MessageVote struct {
+	md      *MessageData
+	ID      int
+	Options []int
+}
+
+// GetData - gets MessageData.
+func (m *MessageVote) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageRetractVote - retracts votes of the user in a poll.
+type MessageRetractVote struct {
+	md *MessageData
+	ID int
+}
+
+// GetData - gets MessageData.
+func (m *MessageRetractVote) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessagePollUpdated - informs subscribers about new results of a poll.
+type MessagePollUpdated struct {
+	md      *MessageData
+	ID      int
+	Channel string
+	Poll    *Poll
+}
+
+// GetData - gets MessageData.
+func (m *MessagePollUpdated) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetGroups - gets a list of groups.
+type MessageGetGroups struct {
+	md     *MessageData
+	Groups []*Group
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetGroups) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetFlags - gets flags from a group by its id.
+type MessageGetFlags struct {
+	md      *MessageData
+	GroupID int
+	Flags   []*Flag
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetFlags) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessagePostDM - sends a direct message to a conversation.
+type MessagePostDM struct {
+	md           *MessageData
+	Content      string
+	Users        []string
+	Conversation int
+	MsgObj       *ChatMessage
+}
+
+// GetData - gets MessageData.
+func (m *MessagePostDM) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetDMHistory - gets direct messages of a conversation.
+type MessageGetDMHistory struct {
+	md           *MessageData
+	Conversation int
+	MessageID    int
+	Amount       int
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetDMHistory) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageGetDMHistoryResult - sends fetched direct messages.
+type MessageGetDMHistoryResult struct {
+	md           *MessageData
+	Conversation int
+	Messages     []*ChatMessage
+}
+
+// GetData - gets MessageData.
+func (m *MessageGetDMHistoryResult) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageListDMs - lists direct message conversations of a user.
+type MessageListDMs struct {
+	md            *MessageData
+	Conversations []*DMConversation
+}
+
+// GetData - gets MessageData.
+func (m *MessageListDMs) GetData() *MessageData {
+	if m.md == nil {
+		m.md = &MessageData{}
+	}
+	return m.md
+}
+
+// MessageMarkRead - marks messages in a channel as read.
+type MessageMarkRead struct {
+	md      *MessageData
+	Channel string
+	ID      int
 }
 
 // GetData - gets MessageData.
-func (m *MessageGetGroups) GetData() *MessageData {
+func (m *MessageMarkRead) GetData() *MessageData {
 	if m.md == nil {
 		m.md = &MessageData{}
 	}
 	return m.md
 }
 
-// MessageGetFlags - gets flags from a group by its id.
-type MessageGetFlags struct {
-	md      *MessageData
-	GroupID int
-	Flags   []*Flag
+// MessageUnreadSummary - gets unread message counts of all visible channels.
+type MessageUnreadSummary struct {
+	md       *MessageData
+	Channels []*UnreadSummary
 }
 
 // GetData - gets MessageData.
-func (m *MessageGetFlags) GetData() *MessageData {
+func (m *MessageUnreadSummary) GetData() *MessageData {
 	if m.md == nil {
 		m.md = &MessageData{}
 	}
@@ -520,7 +1392,11 @@ func LoadMessage(iface map[string]interface{}) (BaseMessage, error) {
 		if _, ok := iface["message"]; !ok {
 			return nil, errors.New("no message field in fail message")
 		}
-		msg = &MessageFail{Message: iface["message"].(string)}
+		m := &MessageFail{Message: iface["message"].(string)}
+		if _, ok := iface["retry-after"]; ok {
+			m.RetryAfter = int(iface["retry-after"].(float64))
+		}
+		msg = m
 	case "register":
 		if _, ok := iface["name"]; !ok {
 			return nil, errors.New("no name field in register message")
@@ -558,6 +1434,22 @@ func LoadMessage(iface map[string]interface{}) (BaseMessage, error) {
 			return nil, errors.New("no topic field in channel-topic message")
 		}
 		msg = &MessageChannelTopic{Name: iface["name"].(string), Topic: iface["topic"].(string)}
+	case "set-channel-retention":
+		if _, ok := iface["name"]; !ok {
+			return nil, errors.New("no name field in set-channel-retention message")
+		}
+		if _, ok := iface["retention"]; !ok {
+			return nil, errors.New("no retention field in set-channel-retention message")
+		}
+		msg = &MessageSetChannelRetention{Name: iface["name"].(string), Retention: int(iface["retention"].(float64))}
+	case "set-channel-slowmode":
+		if _, ok := iface["name"]; !ok {
+			return nil, errors.New("no name field in set-channel-slowmode message")
+		}
+		if _, ok := iface["slowmode"]; !ok {
+			return nil, errors.New("no slowmode field in set-channel-slowmode message")
+		}
+		msg = &MessageSetChannelSlowmode{Name: iface["name"].(string), Slowmode: int(iface["slowmode"].(float64))}
 	case "subscribe":
 		if _, ok := iface["name"]; !ok {
 			return nil, errors.New("no name field in subscribe message")
@@ -573,7 +1465,21 @@ func LoadMessage(iface map[string]interface{}) (BaseMessage, error) {
 		if _, ok := iface["channel"]; !ok {
 			return nil, errors.New("no channel field in send-message message")
 		}
-		msg = &MessagePostMsg{Content: iface["content"].(string), Channel: iface["channel"].(string)}
+		var replyTo int
+		if _, ok := iface["reply-to"]; ok {
+			replyTo = int(iface["reply-to"].(float64))
+		}
+		attachments := []string{}
+		if _, ok := iface["attachments"]; ok {
+			for _, attachment := range iface["attachments"].([]interface{}) {
+				attachments = append(attachments, attachment.(string))
+			}
+		}
+		var ttl int
+		if _, ok := iface["ttl"]; ok {
+			ttl = int(iface["ttl"].(float64))
+		}
+		msg = &MessagePostMsg{Content: iface["content"].(string), Channel: iface["channel"].(string), ReplyTo: replyTo, Attachments: attachments, TTL: ttl}
 	case "get-messages":
 		if _, ok := iface["channel-id"]; !ok {
 			return nil, errors.New("no channel-id field in get-messages message")
@@ -584,12 +1490,81 @@ func LoadMessage(iface map[string]interface{}) (BaseMessage, error) {
 		if _, ok := iface["amount"]; !ok {
 			return nil, errors.New("no amount field in get-messages message")
 		}
-		msg = &MessageGetMsgs{ChannelID: int(iface["channel-id"].(float64)), MessageID: int(iface["message-id"].(float64)), Amount: int(iface["amount"].(float64))}
+		mode := "before"
+		if _, ok := iface["mode"]; ok {
+			mode = iface["mode"].(string)
+		}
+		if mode != "before" && mode != "after" && mode != "around" {
+			return nil, errors.New("invalid mode " + mode + " in get-messages message")
+		}
+		msg = &MessageGetMsgs{ChannelID: int(iface["channel-id"].(float64)), MessageID: int(iface["message-id"].(float64)), Amount: int(iface["amount"].(float64)), Mode: mode}
 	case "get-messages-result":
 		if _, ok := iface["messages"]; !ok {
 			return nil, errors.New("no messages field in get-messages-result message")
 		}
-		msg = &MessageGetMsgsResult{Messages: iface["messages"].([]*ChatMessage)}
+		var hasMoreBefore, hasMoreAfter bool
+		if _, ok := iface["has-more-before"]; ok {
+			hasMoreBefore = iface["has-more-before"].(bool)
+		}
+		if _, ok := iface["has-more-after"]; ok {
+			hasMoreAfter = iface["has-more-after"].(bool)
+		}
+		msg = &MessageGetMsgsResult{Messages: iface["messages"].([]*ChatMessage), HasMoreBefore: hasMoreBefore, HasMoreAfter: hasMoreAfter}
+	case "get-thread":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in get-thread message")
+		}
+		if _, ok := iface["amount"]; !ok {
+			return nil, errors.New("no amount field in get-thread message")
+		}
+		var msgid int
+		if _, ok := iface["message-id"]; ok {
+			msgid = int(iface["message-id"].(float64))
+		}
+		msg = &MessageGetThread{ID: int(iface["id"].(float64)), MessageID: msgid, Amount: int(iface["amount"].(float64))}
+	case "get-thread-result":
+		if _, ok := iface["root"]; !ok {
+			return nil, errors.New("no root field in get-thread-result message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in get-thread-result message")
+		}
+		if _, ok := iface["messages"]; !ok {
+			return nil, errors.New("no messages field in get-thread-result message")
+		}
+		msg = &MessageGetThreadResult{Root: iface["root"].(*ChatMessage), Channel: iface["channel"].(string), Messages: iface["messages"].([]*ChatMessage)}
+	case "search-messages":
+		if _, ok := iface["amount"]; !ok {
+			return nil, errors.New("no amount field in search-messages message")
+		}
+		m := &MessageSearchMsgs{Amount: int(iface["amount"].(float64))}
+		if _, ok := iface["query"]; ok {
+			m.Query = iface["query"].(string)
+		}
+		if _, ok := iface["author"]; ok {
+			m.Author = iface["author"].(string)
+		}
+		if _, ok := iface["channel"]; ok {
+			m.Channel = iface["channel"].(string)
+		}
+		if _, ok := iface["from"]; ok {
+			m.From = iface["from"].(string)
+		}
+		if _, ok := iface["to"]; ok {
+			m.To = iface["to"].(string)
+		}
+		if _, ok := iface["mentions-me"]; ok {
+			m.MentionsMe = iface["mentions-me"].(bool)
+		}
+		if _, ok := iface["message-id"]; ok {
+			m.MessageID = int(iface["message-id"].(float64))
+		}
+		msg = m
+	case "search-messages-result":
+		if _, ok := iface["results"]; !ok {
+			return nil, errors.New("no results field in search-messages-result message")
+		}
+		msg = &MessageSearchMsgsResult{Results: iface["results"].([]*SearchResult)}
 	case "list-channels":
 		channels := []*Channel{}
 		hasChannels := false
@@ -625,15 +1600,72 @@ func LoadMessage(iface map[string]interface{}) (BaseMessage, error) {
 		} else if !hasID && !hasUsername {
 			return nil, errors.New("no id or username field in kick message")
 		}
+		var reason string
+		if _, ok := iface["reason"]; ok {
+			reason = iface["reason"].(string)
+		}
+		var duration int
+		if _, ok := iface["duration"]; ok {
+			duration = int(iface["duration"].(float64))
+		}
 		if _, ok := iface["ban"]; ok {
 			if hasID {
-				msg = &MessageKick{ID: int(iface["id"].(float64)), Ban: iface["ban"].(bool)}
+				msg = &MessageKick{ID: int(iface["id"].(float64)), Ban: iface["ban"].(bool), Reason: reason, Duration: duration}
 			} else if hasUsername {
-				msg = &MessageKick{Username: iface["username"].(string), Ban: iface["ban"].(bool)}
+				msg = &MessageKick{Username: iface["username"].(string), Ban: iface["ban"].(bool), Reason: reason, Duration: duration}
 			}
 		} else {
 			return nil, errors.New("no ban field in kick message")
 		}
+	case "unban":
+		if _, ok := iface["username"]; !ok {
+			return nil, errors.New("no username field in unban message")
+		}
+		msg = &MessageUnban{Username: iface["username"].(string)}
+	case "list-bans":
+		if _, ok := iface["bans"]; ok {
+			msg = &MessageListBans{Bans: iface["bans"].([]*Ban)}
+		} else {
+			msg = &MessageListBans{}
+		}
+	case "timeout":
+		if _, ok := iface["username"]; !ok {
+			return nil, errors.New("no username field in timeout message")
+		}
+		if _, ok := iface["duration"]; !ok {
+			return nil, errors.New("no duration field in timeout message")
+		}
+		m := &MessageTimeout{Username: iface["username"].(string), Duration: int(iface["duration"].(float64))}
+		if _, ok := iface["channel"]; ok {
+			m.Channel = iface["channel"].(string)
+		}
+		if _, ok := iface["reason"]; ok {
+			m.Reason = iface["reason"].(string)
+		}
+		msg = m
+	case "add-ip-ban":
+		if _, ok := iface["range"]; !ok {
+			return nil, errors.New("no range field in add-ip-ban message")
+		}
+		m := &MessageAddIPBan{Range: iface["range"].(string)}
+		if _, ok := iface["reason"]; ok {
+			m.Reason = iface["reason"].(string)
+		}
+		if _, ok := iface["duration"]; ok {
+			m.Duration = int(iface["duration"].(float64))
+		}
+		msg = m
+	case "delete-ip-ban":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in delete-ip-ban message")
+		}
+		msg = &MessageDeleteIPBan{ID: int(iface["id"].(float64))}
+	case "list-ip-bans":
+		if _, ok := iface["bans"]; ok {
+			msg = &MessageListIPBans{Bans: iface["bans"].([]*IPBan)}
+		} else {
+			msg = &MessageListIPBans{}
+		}
 	case "new-group":
 		if _, ok := iface["name"]; !ok {
 			return nil, errors.New("no name field in new-group messsage")
@@ -708,12 +1740,388 @@ func LoadMessage(iface map[string]interface{}) (BaseMessage, error) {
 		if _, ok := iface["channel"]; !ok {
 			return nil, errors.New("no channel field in ping message")
 		}
-		msg = &MessagePing{Message: iface["message"].(*ChatMessage), Channel: iface["channel"].(string)}
+		reason := "mention"
+		if _, ok := iface["reason"]; ok {
+			reason = iface["reason"].(string)
+		}
+		msg = &MessagePing{Message: iface["message"].(*ChatMessage), Channel: iface["channel"].(string), Reason: reason}
 	case "delete-message":
 		if _, ok := iface["id"]; !ok {
 			return nil, errors.New("no id field in delete-message message")
 		}
-		msg = &MessageDeleteMsg{ID: int(iface["id"].(float64))}
+		var reason string
+		if _, ok := iface["reason"]; ok {
+			reason = iface["reason"].(string)
+		}
+		msg = &MessageDeleteMsg{ID: int(iface["id"].(float64)), Reason: reason}
+	case "purge-messages":
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in purge-messages message")
+		}
+		m := &MessagePurgeMsgs{Channel: iface["channel"].(string)}
+		if _, ok := iface["author"]; ok {
+			m.Author = iface["author"].(string)
+		}
+		if _, ok := iface["after"]; ok {
+			m.After = iface["after"].(string)
+		}
+		if _, ok := iface["before"]; ok {
+			m.Before = iface["before"].(string)
+		}
+		if _, ok := iface["last"]; ok {
+			m.Last = int(iface["last"].(float64))
+		}
+		if _, ok := iface["pattern"]; ok {
+			m.Pattern = iface["pattern"].(string)
+		}
+		if _, ok := iface["reason"]; ok {
+			m.Reason = iface["reason"].(string)
+		}
+		msg = m
+	case "messages-purged":
+		if _, ok := iface["ids"]; !ok {
+			return nil, errors.New("no ids field in messages-purged message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in messages-purged message")
+		}
+		msg = &MessageMsgsPurged{IDs: iface["ids"].([]int), Channel: iface["channel"].(string)}
+	case "message-deleted":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in message-deleted message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in message-deleted message")
+		}
+		msg = &MessageMsgDeleted{ID: int(iface["id"].(float64)), Channel: iface["channel"].(string)}
+	case "message-embeds":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in message-embeds message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in message-embeds message")
+		}
+		if _, ok := iface["embeds"]; !ok {
+			return nil, errors.New("no embeds field in message-embeds message")
+		}
+		msg = &MessageMsgEmbeds{ID: int(iface["id"].(float64)), Channel: iface["channel"].(string), Embeds: iface["embeds"].([]*Embed)}
+	case "edit-message":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in edit-message message")
+		}
+		if _, ok := iface["content"]; !ok {
+			return nil, errors.New("no content field in edit-message message")
+		}
+		msg = &MessageEditMsg{ID: int(iface["id"].(float64)), Content: iface["content"].(string)}
+	case "message-edited":
+		if _, ok := iface["message"]; !ok {
+			return nil, errors.New("no message field in message-edited message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in message-edited message")
+		}
+		msg = &MessageMsgEdited{Message: iface["message"].(*ChatMessage), Channel: iface["channel"].(string)}
+	case "get-message-edits":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in get-message-edits message")
+		}
+		if _, ok := iface["edits"]; ok {
+			msg = &MessageGetMsgEdits{ID: int(iface["id"].(float64)), Edits: iface["edits"].([]*MessageEdit)}
+		} else {
+			msg = &MessageGetMsgEdits{ID: int(iface["id"].(float64))}
+		}
+	case "set-notify-prefs":
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in set-notify-prefs message")
+		}
+		m := &MessageSetNotifyPrefs{Channel: iface["channel"].(string)}
+		if _, ok := iface["level"]; ok {
+			m.Level = iface["level"].(string)
+			m.HasLevel = true
+		}
+		if _, ok := iface["muted"]; ok {
+			m.Muted = iface["muted"].(bool)
+			m.HasMuted = true
+		}
+		msg = m
+	case "get-notify-prefs":
+		if _, ok := iface["prefs"]; ok {
+			msg = &MessageGetNotifyPrefs{Prefs: iface["prefs"].([]*NotifyPrefs)}
+		} else {
+			msg = &MessageGetNotifyPrefs{}
+		}
+	case "add-highlight":
+		if _, ok := iface["pattern"]; !ok {
+			return nil, errors.New("no pattern field in add-highlight message")
+		}
+		var regex bool
+		if _, ok := iface["regex"]; ok {
+			regex = iface["regex"].(bool)
+		}
+		msg = &MessageAddHighlight{Pattern: iface["pattern"].(string), Regex: regex}
+	case "delete-highlight":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in delete-highlight message")
+		}
+		msg = &MessageDeleteHighlight{ID: int(iface["id"].(float64))}
+	case "get-highlights":
+		if _, ok := iface["highlights"]; ok {
+			msg = &MessageGetHighlights{Highlights: iface["highlights"].([]*Highlight)}
+		} else {
+			msg = &MessageGetHighlights{}
+		}
+	case "highlight":
+		if _, ok := iface["message"]; !ok {
+			return nil, errors.New("no message field in highlight message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in highlight message")
+		}
+		msg = &MessageHighlight{Message: iface["message"].(*ChatMessage), Channel: iface["channel"].(string)}
+	case "schedule-message":
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in schedule-message message")
+		}
+		if _, ok := iface["content"]; !ok {
+			return nil, errors.New("no content field in schedule-message message")
+		}
+		if _, ok := iface["at"]; !ok {
+			return nil, errors.New("no at field in schedule-message message")
+		}
+		msg = &MessageScheduleMsg{Channel: iface["channel"].(string), Content: iface["content"].(string), At: iface["at"].(string)}
+	case "remind-me":
+		if _, ok := iface["content"]; !ok {
+			return nil, errors.New("no content field in remind-me message")
+		}
+		if _, ok := iface["at"]; !ok {
+			return nil, errors.New("no at field in remind-me message")
+		}
+		var msgid int
+		if _, ok := iface["message-id"]; ok {
+			msgid = int(iface["message-id"].(float64))
+		}
+		msg = &MessageRemindMe{Content: iface["content"].(string), At: iface["at"].(string), MessageID: msgid}
+	case "list-scheduled":
+		if _, ok := iface["items"]; ok {
+			msg = &MessageListScheduled{Items: iface["items"].([]*ScheduledItem)}
+		} else {
+			msg = &MessageListScheduled{}
+		}
+	case "cancel-scheduled":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in cancel-scheduled message")
+		}
+		msg = &MessageCancelScheduled{ID: int(iface["id"].(float64))}
+	case "reminder":
+		if _, ok := iface["reminder"]; !ok {
+			return nil, errors.New("no reminder field in reminder message")
+		}
+		msg = &MessageReminder{Reminder: iface["reminder"].(*ScheduledItem)}
+	case "list-commands":
+		if _, ok := iface["commands"]; ok {
+			msg = &MessageListCommands{Commands: iface["commands"].([]*Command)}
+		} else {
+			msg = &MessageListCommands{}
+		}
+	case "create-poll":
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in create-poll message")
+		}
+		if _, ok := iface["question"]; !ok {
+			return nil, errors.New("no question field in create-poll message")
+		}
+		if _, ok := iface["options"]; !ok {
+			return nil, errors.New("no options field in create-poll message")
+		}
+		if _, ok := iface["ends"]; !ok {
+			return nil, errors.New("no ends field in create-poll message")
+		}
+		options := []string{}
+		for _, option := range iface["options"].([]interface{}) {
+			options = append(options, option.(string))
+		}
+		var multi bool
+		if _, ok := iface["multi"]; ok {
+			multi = iface["multi"].(bool)
+		}
+		msg = &MessageCreatePoll{Channel: iface["channel"].(string), Question: iface["question"].(string), Options: options, Ends: iface["ends"].(string), Multi: multi}
+	case "vote":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in vote message")
+		}
+		if _, ok := iface["options"]; !ok {
+			return nil, errors.New("no options field in vote message")
+		}
+		options := []int{}
+		for _, option := range iface["options"].([]interface{}) {
+			options = append(options, int(option.(float64)))
+		}
+		msg = &MessageVote{ID: int(iface["id"].(float64)), Options: options}
+	case "retract-vote":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in retract-vote message")
+		}
+		msg = &MessageRetractVote{ID: int(iface["id"].(float64))}
+	case "poll-updated":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in poll-updated message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in poll-updated message")
+		}
+		if _, ok := iface["poll"]; !ok {
+			return nil, errors.New("no poll field in poll-updated message")
+		}
+		msg = &MessagePollUpdated{ID: int(iface["id"].(float64)), Channel: iface["channel"].(string), Poll: iface["poll"].(*Poll)}
+	case "get-audit-log":
+		if _, ok := iface["amount"]; !ok {
+			return nil, errors.New("no amount field in get-audit-log message")
+		}
+		m := &MessageGetAuditLog{Amount: int(iface["amount"].(float64))}
+		if _, ok := iface["entry-id"]; ok {
+			m.EntryID = int(iface["entry-id"].(float64))
+		}
+		if _, ok := iface["actor"]; ok {
+			m.Actor = iface["actor"].(string)
+		}
+		if _, ok := iface["action"]; ok {
+			m.Action = iface["action"].(string)
+		}
+		if _, ok := iface["target"]; ok {
+			m.Target = iface["target"].(string)
+		}
+		if _, ok := iface["channel"]; ok {
+			m.Channel = iface["channel"].(string)
+		}
+		msg = m
+	case "get-audit-log-result":
+		if _, ok := iface["entries"]; !ok {
+			return nil, errors.New("no entries field in get-audit-log-result message")
+		}
+		msg = &MessageGetAuditLogResult{Entries: iface["entries"].([]*AuditEntry)}
+	case "get-inbox":
+		if _, ok := iface["amount"]; !ok {
+			return nil, errors.New("no amount field in get-inbox message")
+		}
+		m := &MessageGetInbox{Amount: int(iface["amount"].(float64))}
+		if _, ok := iface["entry-id"]; ok {
+			m.EntryID = int(iface["entry-id"].(float64))
+		}
+		if _, ok := iface["unacked-only"]; ok {
+			m.UnackedOnly = iface["unacked-only"].(bool)
+		}
+		msg = m
+	case "get-inbox-result":
+		if _, ok := iface["entries"]; !ok {
+			return nil, errors.New("no entries field in get-inbox-result message")
+		}
+		msg = &MessageGetInboxResult{Entries: iface["entries"].([]*InboxEntry)}
+	case "ack-inbox":
+		_, hasIDs := iface["ids"]
+		_, hasAll := iface["all"]
+		if hasIDs && hasAll {
+			return nil, errors.New("you can't have ids and all fields together in ack-inbox message")
+		} else if hasIDs {
+			ids := []int{}
+			for _, id := range iface["ids"].([]interface{}) {
+				ids = append(ids, int(id.(float64)))
+			}
+			msg = &MessageAckInbox{IDs: ids}
+		} else if hasAll {
+			msg = &MessageAckInbox{All: iface["all"].(bool)}
+		} else {
+			return nil, errors.New("no ids or all field in ack-inbox message")
+		}
+	case "inbox-count":
+		if _, ok := iface["count"]; !ok {
+			return nil, errors.New("no count field in inbox-count message")
+		}
+		msg = &MessageInboxCount{Count: int(iface["count"].(float64))}
+	case "pin-message":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in pin-message message")
+		}
+		msg = &MessagePinMsg{ID: int(iface["id"].(float64))}
+	case "unpin-message":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in unpin-message message")
+		}
+		msg = &MessageUnpinMsg{ID: int(iface["id"].(float64))}
+	case "message-pinned":
+		if _, ok := iface["message"]; !ok {
+			return nil, errors.New("no message field in message-pinned message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in message-pinned message")
+		}
+		if _, ok := iface["username"]; !ok {
+			return nil, errors.New("no username field in message-pinned message")
+		}
+		msg = &MessageMsgPinned{Message: iface["message"].(*ChatMessage), Channel: iface["channel"].(string), Username: iface["username"].(string)}
+	case "message-unpinned":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in message-unpinned message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in message-unpinned message")
+		}
+		if _, ok := iface["username"]; !ok {
+			return nil, errors.New("no username field in message-unpinned message")
+		}
+		msg = &MessageMsgUnpinned{ID: int(iface["id"].(float64)), Channel: iface["channel"].(string), Username: iface["username"].(string)}
+	case "get-pins":
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in get-pins message")
+		}
+		if _, ok := iface["messages"]; ok {
+			msg = &MessageGetPins{Channel: iface["channel"].(string), Messages: iface["messages"].([]*ChatMessage)}
+		} else {
+			msg = &MessageGetPins{Channel: iface["channel"].(string)}
+		}
+	case "add-reaction":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in add-reaction message")
+		}
+		if _, ok := iface["emoji"]; !ok {
+			return nil, errors.New("no emoji field in add-reaction message")
+		}
+		msg = &MessageAddReaction{ID: int(iface["id"].(float64)), Emoji: iface["emoji"].(string)}
+	case "remove-reaction":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in remove-reaction message")
+		}
+		if _, ok := iface["emoji"]; !ok {
+			return nil, errors.New("no emoji field in remove-reaction message")
+		}
+		msg = &MessageRemoveReaction{ID: int(iface["id"].(float64)), Emoji: iface["emoji"].(string)}
+	case "reaction-added":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in reaction-added message")
+		}
+		if _, ok := iface["emoji"]; !ok {
+			return nil, errors.New("no emoji field in reaction-added message")
+		}
+		if _, ok := iface["username"]; !ok {
+			return nil, errors.New("no username field in reaction-added message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in reaction-added message")
+		}
+		msg = &MessageReactionAdded{ID: int(iface["id"].(float64)), Emoji: iface["emoji"].(string), Username: iface["username"].(string), Channel: iface["channel"].(string)}
+	case "reaction-removed":
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in reaction-removed message")
+		}
+		if _, ok := iface["emoji"]; !ok {
+			return nil, errors.New("no emoji field in reaction-removed message")
+		}
+		if _, ok := iface["username"]; !ok {
+			return nil, errors.New("no username field in reaction-removed message")
+		}
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in reaction-removed message")
+		}
+		msg = &MessageReactionRemoved{ID: int(iface["id"].(float64)), Emoji: iface["emoji"].(string), Username: iface["username"].(string), Channel: iface["channel"].(string)}
 	case "get-groups":
 		if _, ok := iface["groups"]; ok {
 			msg = &MessageGetGroups{Groups: iface["groups"].([]*Group)}
@@ -730,6 +2138,64 @@ func LoadMessage(iface map[string]interface{}) (BaseMessage, error) {
 		} else {
 			msg = &MessageGetFlags{GroupID: groupid}
 		}
+	case "post-dm":
+		if _, ok := iface["content"]; !ok {
+			return nil, errors.New("no content field in post-dm message")
+		}
+		_, hasUsers := iface["users"]
+		_, hasConversation := iface["conversation"]
+		if hasUsers && hasConversation {
+			return nil, errors.New("you can't have users and conversation fields together in post-dm message")
+		} else if hasUsers {
+			users := []string{}
+			for _, user := range iface["users"].([]interface{}) {
+				users = append(users, user.(string))
+			}
+			msg = &MessagePostDM{Content: iface["content"].(string), Users: users}
+		} else if hasConversation {
+			msg = &MessagePostDM{Content: iface["content"].(string), Conversation: int(iface["conversation"].(float64))}
+		} else {
+			return nil, errors.New("no users or conversation field in post-dm message")
+		}
+	case "get-dm-history":
+		if _, ok := iface["conversation"]; !ok {
+			return nil, errors.New("no conversation field in get-dm-history message")
+		}
+		if _, ok := iface["message-id"]; !ok {
+			return nil, errors.New("no message-id field in get-dm-history message")
+		}
+		if _, ok := iface["amount"]; !ok {
+			return nil, errors.New("no amount field in get-dm-history message")
+		}
+		msg = &MessageGetDMHistory{Conversation: int(iface["conversation"].(float64)), MessageID: int(iface["message-id"].(float64)), Amount: int(iface["amount"].(float64))}
+	case "get-dm-history-result":
+		if _, ok := iface["conversation"]; !ok {
+			return nil, errors.New("no conversation field in get-dm-history-result message")
+		}
+		if _, ok := iface["messages"]; !ok {
+			return nil, errors.New("no messages field in get-dm-history-result message")
+		}
+		msg = &MessageGetDMHistoryResult{Conversation: int(iface["conversation"].(float64)), Messages: iface["messages"].([]*ChatMessage)}
+	case "list-dms":
+		if _, ok := iface["conversations"]; ok {
+			msg = &MessageListDMs{Conversations: iface["conversations"].([]*DMConversation)}
+		} else {
+			msg = &MessageListDMs{}
+		}
+	case "mark-read":
+		if _, ok := iface["channel"]; !ok {
+			return nil, errors.New("no channel field in mark-read message")
+		}
+		if _, ok := iface["id"]; !ok {
+			return nil, errors.New("no id field in mark-read message")
+		}
+		msg = &MessageMarkRead{Channel: iface["channel"].(string), ID: int(iface["id"].(float64))}
+	case "unread-summary":
+		if _, ok := iface["channels"]; ok {
+			msg = &MessageUnreadSummary{Channels: iface["channels"].([]*UnreadSummary)}
+		} else {
+			msg = &MessageUnreadSummary{}
+		}
 	}
 
 	if msg != nil {
@@ -760,7 +2226,11 @@ func MessageToIface(msg BaseMessage) (map[string]interface{}, error) {
 	case *MessageOk:
 		out = map[string]interface{}{"type": "ok", "message": msg.(*MessageOk).Message}
 	case *MessageFail:
-		out = map[string]interface{}{"type": "fail", "message": msg.(*MessageFail).Message}
+		if msg.(*MessageFail).RetryAfter == 0 {
+			out = map[string]interface{}{"type": "fail", "message": msg.(*MessageFail).Message}
+		} else {
+			out = map[string]interface{}{"type": "fail", "message": msg.(*MessageFail).Message, "retry-after": msg.(*MessageFail).RetryAfter}
+		}
 	case *MessageRegister:
 		if msg.(*MessageRegister).Pass == "" {
 			out = map[string]interface{}{"type": "register", "name": msg.(*MessageRegister).Name}
@@ -779,6 +2249,10 @@ func MessageToIface(msg BaseMessage) (map[string]interface{}, error) {
 		out = map[string]interface{}{"type": "delete-channel", "name": msg.(*MessageDeleteChannel).Name}
 	case *MessageChannelTopic:
 		out = map[string]interface{}{"type": "channel-topic", "name": msg.(*MessageChannelTopic).Name, "topic": msg.(*MessageChannelTopic).Topic}
+	case *MessageSetChannelRetention:
+		out = map[string]interface{}{"type": "set-channel-retention", "name": msg.(*MessageSetChannelRetention).Name, "retention": msg.(*MessageSetChannelRetention).Retention}
+	case *MessageSetChannelSlowmode:
+		out = map[string]interface{}{"type": "set-channel-slowmode", "name": msg.(*MessageSetChannelSlowmode).Name, "slowmode": msg.(*MessageSetChannelSlowmode).Slowmode}
 	case *MessageSubscribe:
 		out = map[string]interface{}{"type": "subscribe", "name": msg.(*MessageSubscribe).Name, "subbed": msg.(*MessageSubscribe).Subbed}
 	case *MessagePostMsg:
@@ -788,9 +2262,18 @@ func MessageToIface(msg BaseMessage) (map[string]interface{}, error) {
 			out = map[string]interface{}{"type": "post-message", "content": msg.(*MessagePostMsg).Content, "channel": msg.(*MessagePostMsg).Channel}
 		}
 	case *MessageGetMsgs:
-		out = map[string]interface{}{"type": "get-messages", "channel-id": msg.(*MessageGetMsgs).ChannelID, "message-id": msg.(*MessageGetMsgs).MessageID, "amount": msg.(*MessageGetMsgs).Amount}
+		out = map[string]interface{}{"type": "get-messages", "channel-id": msg.(*MessageGetMsgs).ChannelID, "message-id": msg.(*MessageGetMsgs).MessageID, "amount": msg.(*MessageGetMsgs).Amount, "mode": msg.(*MessageGetMsgs).Mode}
 	case *MessageGetMsgsResult:
-		out = map[string]interface{}{"type": "get-messages-result", "messages": msg.(*MessageGetMsgsResult).Messages}
+		out = map[string]interface{}{"type": "get-messages-result", "messages": msg.(*MessageGetMsgsResult).Messages, "has-more-before": msg.(*MessageGetMsgsResult).HasMoreBefore, "has-more-after": msg.(*MessageGetMsgsResult).HasMoreAfter}
+	case *MessageGetThread:
+		out = map[string]interface{}{"type": "get-thread", "id": msg.(*MessageGetThread).ID, "message-id": msg.(*MessageGetThread).MessageID, "amount": msg.(*MessageGetThread).Amount}
+	case *MessageGetThreadResult:
+		out = map[string]interface{}{"type": "get-thread-result", "root": msg.(*MessageGetThreadResult).Root, "channel": msg.(*MessageGetThreadResult).Channel, "messages": msg.(*MessageGetThreadResult).Messages}
+	case *MessageSearchMsgs:
+		m := msg.(*MessageSearchMsgs)
+		out = map[string]interface{}{"type": "search-messages", "query": m.Query, "author": m.Author, "channel": m.Channel, "from": m.From, "to": m.To, "mentions-me": m.MentionsMe, "message-id": m.MessageID, "amount": m.Amount}
+	case *MessageSearchMsgsResult:
+		out = map[string]interface{}{"type": "search-messages-result", "results": msg.(*MessageSearchMsgsResult).Results}
 	case *MessageListChannels:
 		if msg.(*MessageListChannels).HasChannels {
 			out = map[string]interface{}{"type": "list-channels", "channels": msg.(*MessageListChannels).Channels}
@@ -807,9 +2290,29 @@ func MessageToIface(msg BaseMessage) (map[string]interface{}, error) {
 		out = map[string]interface{}{"type": "user-quit", "username": msg.(*MessageUserQuit).Username}
 	case *MessageKick:
 		if msg.(*MessageKick).ID == 0 {
-			out = map[string]interface{}{"type": "kick", "username": msg.(*MessageKick).Username, "ban": msg.(*MessageKick).Ban}
+			out = map[string]interface{}{"type": "kick", "username": msg.(*MessageKick).Username, "ban": msg.(*MessageKick).Ban, "reason": msg.(*MessageKick).Reason, "duration": msg.(*MessageKick).Duration}
 		} else if msg.(*MessageKick).Username == "" {
-			out = map[string]interface{}{"type": "kick", "id": msg.(*MessageKick).ID, "ban": msg.(*MessageKick).Ban}
+			out = map[string]interface{}{"type": "kick", "id": msg.(*MessageKick).ID, "ban": msg.(*MessageKick).Ban, "reason": msg.(*MessageKick).Reason, "duration": msg.(*MessageKick).Duration}
+		}
+	case *MessageUnban:
+		out = map[string]interface{}{"type": "unban", "username": msg.(*MessageUnban).Username}
+	case *MessageListBans:
+		if msg.(*MessageListBans).Bans == nil {
+			out = map[string]interface{}{"type": "list-bans"}
+		} else {
+			out = map[string]interface{}{"type": "list-bans", "bans": msg.(*MessageListBans).Bans}
+		}
+	case *MessageTimeout:
+		out = map[string]interface{}{"type": "timeout", "username": msg.(*MessageTimeout).Username, "channel": msg.(*MessageTimeout).Channel, "duration": msg.(*MessageTimeout).Duration, "reason": msg.(*MessageTimeout).Reason}
+	case *MessageAddIPBan:
+		out = map[string]interface{}{"type": "add-ip-ban", "range": msg.(*MessageAddIPBan).Range, "reason": msg.(*MessageAddIPBan).Reason, "duration": msg.(*MessageAddIPBan).Duration}
+	case *MessageDeleteIPBan:
+		out = map[string]interface{}{"type": "delete-ip-ban", "id": msg.(*MessageDeleteIPBan).ID}
+	case *MessageListIPBans:
+		if msg.(*MessageListIPBans).Bans == nil {
+			out = map[string]interface{}{"type": "list-ip-bans"}
+		} else {
+			out = map[string]interface{}{"type": "list-ip-bans", "bans": msg.(*MessageListIPBans).Bans}
 		}
 	case *MessageNewGroup:
 		out = map[string]interface{}{"type": "new-group", "name": msg.(*MessageNewGroup).Name}
@@ -836,9 +2339,124 @@ func MessageToIface(msg BaseMessage) (map[string]interface{}, error) {
 			out = map[string]interface{}{"type": "get-group-holders", "group-holders": msg.(*MessageGetGroupHolders).GroupHolders}
 		}
 	case *MessagePing:
-		out = map[string]interface{}{"type": "ping", "message": msg.(*MessagePing).Message, "channel": msg.(*MessagePing).Channel}
+		out = map[string]interface{}{"type": "ping", "message": msg.(*MessagePing).Message, "channel": msg.(*MessagePing).Channel, "reason": msg.(*MessagePing).Reason}
 	case *MessageDeleteMsg:
-		out = map[string]interface{}{"type": "delete-message", "id": msg.(*MessageDeleteMsg).ID}
+		if msg.(*MessageDeleteMsg).Reason == "" {
+			out = map[string]interface{}{"type": "delete-message", "id": msg.(*MessageDeleteMsg).ID}
+		} else {
+			out = map[string]interface{}{"type": "delete-message", "id": msg.(*MessageDeleteMsg).ID, "reason": msg.(*MessageDeleteMsg).Reason}
+		}
+	case *MessageMsgDeleted:
+		out = map[string]interface{}{"type": "message-deleted", "id": msg.(*MessageMsgDeleted).ID, "channel": msg.(*MessageMsgDeleted).Channel}
+	case *MessagePurgeMsgs:
+		out = map[string]interface{}{"type": "purge-messages", "channel": msg.(*MessagePurgeMsgs).Channel, "author": msg.(*MessagePurgeMsgs).Author, "after": msg.(*MessagePurgeMsgs).After, "before": msg.(*MessagePurgeMsgs).Before, "last": msg.(*MessagePurgeMsgs).Last, "pattern": msg.(*MessagePurgeMsgs).Pattern, "reason": msg.(*MessagePurgeMsgs).Reason}
+	case *MessageMsgsPurged:
+		out = map[string]interface{}{"type": "messages-purged", "ids": msg.(*MessageMsgsPurged).IDs, "channel": msg.(*MessageMsgsPurged).Channel}
+	case *MessageMsgEmbeds:
+		out = map[string]interface{}{"type": "message-embeds", "id": msg.(*MessageMsgEmbeds).ID, "channel": msg.(*MessageMsgEmbeds).Channel, "embeds": msg.(*MessageMsgEmbeds).Embeds}
+	case *MessageEditMsg:
+		out = map[string]interface{}{"type": "edit-message", "id": msg.(*MessageEditMsg).ID, "content": msg.(*MessageEditMsg).Content}
+	case *MessageMsgEdited:
+		out = map[string]interface{}{"type": "message-edited", "message": msg.(*MessageMsgEdited).Message, "channel": msg.(*MessageMsgEdited).Channel}
+	case *MessageGetMsgEdits:
+		if msg.(*MessageGetMsgEdits).Edits == nil {
+			out = map[string]interface{}{"type": "get-message-edits", "id": msg.(*MessageGetMsgEdits).ID}
+		} else {
+			out = map[string]interface{}{"type": "get-message-edits", "id": msg.(*MessageGetMsgEdits).ID, "edits": msg.(*MessageGetMsgEdits).Edits}
+		}
+	case *MessageAddReaction:
+		out = map[string]interface{}{"type": "add-reaction", "id": msg.(*MessageAddReaction).ID, "emoji": msg.(*MessageAddReaction).Emoji}
+	case *MessageRemoveReaction:
+		out = map[string]interface{}{"type": "remove-reaction", "id": msg.(*MessageRemoveReaction).ID, "emoji": msg.(*MessageRemoveReaction).Emoji}
+	case *MessageReactionAdded:
+		out = map[string]interface{}{"type": "reaction-added", "id": msg.(*MessageReactionAdded).ID, "emoji": msg.(*MessageReactionAdded).Emoji, "username": msg.(*MessageReactionAdded).Username, "channel": msg.(*MessageReactionAdded).Channel}
+	case *MessageReactionRemoved:
+		out = map[string]interface{}{"type": "reaction-removed", "id": msg.(*MessageReactionRemoved).ID, "emoji": msg.(*MessageReactionRemoved).Emoji, "username": msg.(*MessageReactionRemoved).Username, "channel": msg.(*MessageReactionRemoved).Channel}
+	case *MessageSetNotifyPrefs:
+		m := msg.(*MessageSetNotifyPrefs)
+		out = map[string]interface{}{"type": "set-notify-prefs", "channel": m.Channel}
+		if m.HasLevel {
+			out["level"] = m.Level
+		}
+		if m.HasMuted {
+			out["muted"] = m.Muted
+		}
+	case *MessageGetNotifyPrefs:
+		if msg.(*MessageGetNotifyPrefs).Prefs == nil {
+			out = map[string]interface{}{"type": "get-notify-prefs"}
+		} else {
+			out = map[string]interface{}{"type": "get-notify-prefs", "prefs": msg.(*MessageGetNotifyPrefs).Prefs}
+		}
+	case *MessageAddHighlight:
+		out = map[string]interface{}{"type": "add-highlight", "pattern": msg.(*MessageAddHighlight).Pattern, "regex": msg.(*MessageAddHighlight).Regex}
+	case *MessageDeleteHighlight:
+		out = map[string]interface{}{"type": "delete-highlight", "id": msg.(*MessageDeleteHighlight).ID}
+	case *MessageGetHighlights:
+		if msg.(*MessageGetHighlights).Highlights == nil {
+			out = map[string]interface{}{"type": "get-highlights"}
+		} else {
+			out = map[string]interface{}{"type": "get-highlights", "highlights": msg.(*MessageGetHighlights).Highlights}
+		}
+	case *MessageHighlight:
+		out = map[string]interface{}{"type": "highlight", "message": msg.(*MessageHighlight).Message, "channel": msg.(*MessageHighlight).Channel}
+	case *MessageScheduleMsg:
+		out = map[string]interface{}{"type": "schedule-message", "channel": msg.(*MessageScheduleMsg).Channel, "content": msg.(*MessageScheduleMsg).Content, "at": msg.(*MessageScheduleMsg).At}
+	case *MessageRemindMe:
+		out = map[string]interface{}{"type": "remind-me", "content": msg.(*MessageRemindMe).Content, "at": msg.(*MessageRemindMe).At, "message-id": msg.(*MessageRemindMe).MessageID}
+	case *MessageListScheduled:
+		if msg.(*MessageListScheduled).Items == nil {
+			out = map[string]interface{}{"type": "list-scheduled"}
+		} else {
+			out = map[string]interface{}{"type": "list-scheduled", "items": msg.(*MessageListScheduled).Items}
+		}
+	case *MessageCancelScheduled:
+		out = map[string]interface{}{"type": "cancel-scheduled", "id": msg.(*MessageCancelScheduled).ID}
+	case *MessageReminder:
+		out = map[string]interface{}{"type": "reminder", "reminder": msg.(*MessageReminder).Reminder}
+	case *MessageListCommands:
+		if msg.(*MessageListCommands).Commands == nil {
+			out = map[string]interface{}{"type": "list-commands"}
+		} else {
+			out = map[string]interface{}{"type": "list-commands", "commands": msg.(*MessageListCommands).Commands}
+		}
+	case *MessageCreatePoll:
+		out = map[string]interface{}{"type": "create-poll", "channel": msg.(*MessageCreatePoll).Channel, "question": msg.(*MessageCreatePoll).Question, "options": msg.(*MessageCreatePoll).Options, "ends": msg.(*MessageCreatePoll).Ends, "multi": msg.(*MessageCreatePoll).Multi}
+	case *MessageVote:
+		out = map[string]interface{}{"type": "vote", "id": msg.(*MessageVote).ID, "options": msg.(*MessageVote).Options}
+	case *MessageRetractVote:
+		out = map[string]interface{}{"type": "retract-vote", "id": msg.(*MessageRetractVote).ID}
+	case *MessagePollUpdated:
+		out = map[string]interface{}{"type": "poll-updated", "id": msg.(*MessagePollUpdated).ID, "channel": msg.(*MessagePollUpdated).Channel, "poll": msg.(*MessagePollUpdated).Poll}
+	case *MessageGetInbox:
+		out = map[string]interface{}{"type": "get-inbox", "entry-id": msg.(*MessageGetInbox).EntryID, "amount": msg.(*MessageGetInbox).Amount, "unacked-only": msg.(*MessageGetInbox).UnackedOnly}
+	case *MessageGetAuditLog:
+		out = map[string]interface{}{"type": "get-audit-log", "entry-id": msg.(*MessageGetAuditLog).EntryID, "amount": msg.(*MessageGetAuditLog).Amount, "actor": msg.(*MessageGetAuditLog).Actor, "action": msg.(*MessageGetAuditLog).Action, "target": msg.(*MessageGetAuditLog).Target, "channel": msg.(*MessageGetAuditLog).Channel}
+	case *MessageGetAuditLogResult:
+		out = map[string]interface{}{"type": "get-audit-log-result", "entries": msg.(*MessageGetAuditLogResult).Entries}
+	case *MessageGetInboxResult:
+		out = map[string]interface{}{"type": "get-inbox-result", "entries": msg.(*MessageGetInboxResult).Entries}
+	case *MessageAckInbox:
+		if msg.(*MessageAckInbox).IDs == nil {
+			out = map[string]interface{}{"type": "ack-inbox", "all": msg.(*MessageAckInbox).All}
+		} else {
+			out = map[string]interface{}{"type": "ack-inbox", "ids": msg.(*MessageAckInbox).IDs}
+		}
+	case *MessageInboxCount:
+		out = map[string]interface{}{"type": "inbox-count", "count": msg.(*MessageInboxCount).Count}
+	case *MessagePinMsg:
+		out = map[string]interface{}{"type": "pin-message", "id": msg.(*MessagePinMsg).ID}
+	case *MessageUnpinMsg:
+		out = map[string]interface{}{"type": "unpin-message", "id": msg.(*MessageUnpinMsg).ID}
+	case *MessageMsgPinned:
+		out = map[string]interface{}{"type": "message-pinned", "message": msg.(*MessageMsgPinned).Message, "channel": msg.(*MessageMsgPinned).Channel, "username": msg.(*MessageMsgPinned).Username}
+	case *MessageMsgUnpinned:
+		out = map[string]interface{}{"type": "message-unpinned", "id": msg.(*MessageMsgUnpinned).ID, "channel": msg.(*MessageMsgUnpinned).Channel, "username": msg.(*MessageMsgUnpinned).Username}
+	case *MessageGetPins:
+		if msg.(*MessageGetPins).Messages == nil {
+			out = map[string]interface{}{"type": "get-pins", "channel": msg.(*MessageGetPins).Channel}
+		} else {
+			out = map[string]interface{}{"type": "get-pins", "channel": msg.(*MessageGetPins).Channel, "messages": msg.(*MessageGetPins).Messages}
+		}
 	case *MessageGetGroups:
 		out = map[string]interface{}{"type": "get-groups", "groups": msg.(*MessageGetGroups).Groups}
 	case *MessageGetFlags:
@@ -847,6 +2465,24 @@ func MessageToIface(msg BaseMessage) (map[string]interface{}, error) {
 		} else {
 			out = map[string]interface{}{"type": "get-flags", "group-id": msg.(*MessageGetFlags).GroupID}
 		}
+	case *MessagePostDM:
+		if msg.(*MessagePostDM).MsgObj != nil {
+			out = map[string]interface{}{"type": "post-dm", "message": msg.(*MessagePostDM).MsgObj, "conversation": msg.(*MessagePostDM).Conversation, "participants": msg.(*MessagePostDM).Users}
+		} else if msg.(*MessagePostDM).Conversation != 0 {
+			out = map[string]interface{}{"type": "post-dm", "content": msg.(*MessagePostDM).Content, "conversation": msg.(*MessagePostDM).Conversation}
+		} else {
+			out = map[string]interface{}{"type": "post-dm", "content": msg.(*MessagePostDM).Content, "users": msg.(*MessagePostDM).Users}
+		}
+	case *MessageGetDMHistory:
+		out = map[string]interface{}{"type": "get-dm-history", "conversation": msg.(*MessageGetDMHistory).Conversation, "message-id": msg.(*MessageGetDMHistory).MessageID, "amount": msg.(*MessageGetDMHistory).Amount}
+	case *MessageGetDMHistoryResult:
+		out = map[string]interface{}{"type": "get-dm-history-result", "conversation": msg.(*MessageGetDMHistoryResult).Conversation, "messages": msg.(*MessageGetDMHistoryResult).Messages}
+	case *MessageListDMs:
+		out = map[string]interface{}{"type": "list-dms", "conversations": msg.(*MessageListDMs).Conversations}
+	case *MessageMarkRead:
+		out = map[string]interface{}{"type": "mark-read", "channel": msg.(*MessageMarkRead).Channel, "id": msg.(*MessageMarkRead).ID}
+	case *MessageUnreadSummary:
+		out = map[string]interface{}{"type": "unread-summary", "channels": msg.(*MessageUnreadSummary).Channels}
 	default:
 		return nil, errors.New("invalid type")
 	}
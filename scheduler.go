@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+)
+
+const (
+	// schedulerInterval - how often the scheduler looks for scheduled items whose time has come
+	schedulerInterval = 10 * time.Second
+	// maxScheduleAhead - how far in the future items can be scheduled
+	maxScheduleAhead = 365 * 24 * time.Hour
+	// maxScheduledItems - maximum amount of pending scheduled items of a user
+	maxScheduledItems = 50
+	// blobSweepInterval - how often files of deleted and never attached uploads are looked for
+	blobSweepInterval = time.Hour
+	// maxUnattachedAge - uploads not attached to a message within this time are deleted
+	maxUnattachedAge = 24 * time.Hour
+)
+
+// runScheduler - delivers scheduled messages and reminders, closes polls, deletes expired messages, bans and timeouts
+// and forgets old slowmode posts when their time comes. State is kept in the database, so items scheduled before a
+// restart are delivered afterwards
+func runScheduler(mel *Melodious) {
+	lastSweep := time.Time{}
+	for {
+		if time.Since(lastSweep) >= blobSweepInterval {
+			sweepBlobs(mel)
+			lastSweep = time.Now()
+		}
+		postDueMessages(mel)
+		closeDuePolls(mel)
+		deleted, blobs, err := mel.Database.DeleteExpiredMessages()
+		if err != nil {
+			log.WithField("err", err).Error("error when deleting expired messages")
+		}
+		deleteBlobs(mel, blobs)
+		announceDeletedMessages(mel, deleted)
+		err = mel.Database.DeleteExpiredBans()
+		if err != nil {
+			log.WithField("err", err).Error("error when deleting expired bans")
+		}
+		mel.PruneSlowmode(maxSlowmode * time.Second)
+		usernames, err := mel.Database.GetUsersWithDueReminders()
+		if err != nil {
+			log.WithField("err", err).Error("error when looking for due reminders")
+		}
+		for _, username := range usernames {
+			// offline users get their reminders on login
+			if mel.IsOnline(username) {
+				deliverReminders(mel, username)
+				deliverFailedMessages(mel, username)
+			}
+		}
+		time.Sleep(schedulerInterval)
+	}
+}
+
+// postDueMessages - posts scheduled messages whose time has come. Messages which cannot be posted anymore are marked as
+// failed and their authors are notified when they are online
+func postDueMessages(mel *Melodious) {
+	// messages which could not be posted because of an error are retried on the next run
+	skip := []int{}
+	for {
+		var item *ScheduledItem
+		undoSlowmode := func() {}
+		msg, more, err := mel.Database.TakeDueMessage(skip, func(due *ScheduledItem) (*ScheduledPost, error) {
+			item = due
+			post, undo, err := prepareScheduledMessage(mel, due)
+			undoSlowmode = undo
+			return post, err
+		})
+		if err != nil {
+			undoSlowmode()
+			if item != nil {
+				skip = append(skip, item.ID)
+			}
+			log.WithField("err", err).Error("error when posting a scheduled message")
+		} else if msg != nil {
+			// subscribers only learn about the message once it is committed
+			broadcastMessage(mel, item.Channel, msg)
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// prepareScheduledMessage - checks whether a scheduled message can be posted and resolves its pings. The message is
+// refused if its author cannot post it anymore, or delayed if slowmode does not allow it yet. The returned function
+// forgets the slowmode post if the message ends up not being posted
+func prepareScheduledMessage(mel *Melodious, item *ScheduledItem) (*ScheduledPost, func(), error) {
+	nothing := func() {}
+	// permissions might have changed since the message was scheduled
+	can, err := mel.HasPerm(item.Author, item.Channel, "perms.post-message")
+	if err != nil {
+		return nil, nothing, err
+	}
+	if !can {
+		return &ScheduledPost{Failure: "no permissions"}, nothing, nil
+	}
+	timeout, err := mel.Database.GetTimeout(item.Author, item.Channel)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nothing, err
+	} else if err == nil {
+		return &ScheduledPost{Failure: "you are timed out until " + timeout.Expires}, nothing, nil
+	}
+	undoSlowmode, wait, err := takeScheduledSlowmode(mel, item)
+	if err != nil {
+		return nil, nothing, err
+	} else if wait != 0 {
+		return &ScheduledPost{Delay: wait}, nothing, nil
+	}
+	pings, massPing, _, err := resolvePings(mel, item.Author, item.Channel, item.Content)
+	if err != nil {
+		undoSlowmode()
+		return nil, nothing, err
+	}
+	return &ScheduledPost{Pings: pings, MassPing: massPing}, undoSlowmode, nil
+}
+
+// takeScheduledSlowmode - records a post of a scheduled message to a channel in slowmode, like checkSlowmode. Returns
+// in how many seconds the message can be posted if the author has to wait
+func takeScheduledSlowmode(mel *Melodious, item *ScheduledItem) (func(), int, error) {
+	nothing := func() {}
+	chnl, err := mel.Database.GetChannel(item.Channel)
+	if err != nil {
+		return nothing, 0, err
+	}
+	if chnl.Slowmode == 0 {
+		return nothing, 0, nil
+	}
+	can, err := mel.HasPerm(item.Author, item.Channel, "perms.bypass-slowmode")
+	if err != nil {
+		return nothing, 0, err
+	} else if can {
+		return nothing, 0, nil
+	}
+	wait, undo := mel.TakeSlowmode(item.Author, item.Channel, time.Duration(chnl.Slowmode)*time.Second)
+	if wait > 0 {
+		return nothing, retryAfter(wait), nil
+	}
+	return undo, 0, nil
+}
+
+// closeDuePolls - closes polls whose deadline has passed and sends their final results
+func closeDuePolls(mel *Melodious) {
+	ids, err := mel.Database.CloseDuePolls()
+	if err != nil {
+		log.WithField("err", err).Error("error when closing due polls")
+		return
+	}
+	for _, id := range ids {
+		channel, _, err := mel.Database.GetMessageDetails(id)
+		if err != nil {
+			log.WithFields(log.Fields{"id": id, "err": err}).Error("error when fetching details of a closed poll")
+			continue
+		}
+		broadcastPoll(mel, channel, id)
+	}
+}
+
+// announceDeletedMessages - notifies subscribers about messages deleted by the server. deleted maps channel names to message IDs
+func announceDeletedMessages(mel *Melodious, deleted map[string][]int) {
+	for channel, ids := range deleted {
+		event := &MessageMsgsPurged{IDs: ids, Channel: channel}
+		mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+			connInfo.messageStream <- event
+		})
+	}
+}
+
+// deleteBlobs - deletes files of deleted attachments. Files which cannot be deleted now are picked up by sweepBlobs
+func deleteBlobs(mel *Melodious, ids []string) {
+	for _, id := range ids {
+		err := mel.Blobs.Delete(id)
+		if err != nil && !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"id": id, "err": err}).Error("error when deleting a file")
+		}
+	}
+}
+
+// sweepBlobs - deletes uploads which were never attached to a message, old link previews with their images and files
+// left over by deleted attachments
+func sweepBlobs(mel *Melodious) {
+	blobs, err := mel.Database.DeleteUnattachedUploads(int(maxUnattachedAge.Seconds()))
+	if err != nil {
+		log.WithField("err", err).Error("error when deleting unattached uploads")
+		return
+	}
+	deleteBlobs(mel, blobs)
+	blobs, err = mel.Database.DeleteOldEmbeds()
+	if err != nil {
+		log.WithField("err", err).Error("error when deleting old link previews")
+		return
+	}
+	deleteBlobs(mel, blobs)
+	// recently stored files might belong to uploads which are not in the database yet
+	stored, err := mel.Blobs.List(time.Now().Add(-blobSweepInterval))
+	if err != nil {
+		log.WithField("err", err).Error("error when listing stored files")
+		return
+	}
+	orphans, err := mel.Database.FindOrphanedBlobs(stored)
+	if err != nil {
+		log.WithField("err", err).Error("error when looking for orphaned files")
+		return
+	}
+	deleteBlobs(mel, orphans)
+}
+
+// deliverReminders - sends a user their reminders whose time has come
+func deliverReminders(mel *Melodious, username string) {
+	items, err := mel.Database.TakeDueReminders(username)
+	if err != nil {
+		log.WithFields(log.Fields{"name": username, "err": err}).Error("error when fetching due reminders")
+		return
+	}
+	for _, item := range items {
+		event := &MessageReminder{Reminder: item}
+		mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+			connInfo.messageStream <- event
+		})
+	}
+}
+
+// deliverFailedMessages - notifies a user about their scheduled messages which could not be posted
+func deliverFailedMessages(mel *Melodious, username string) {
+	items, err := mel.Database.TakeFailedMessages(username)
+	if err != nil {
+		log.WithFields(log.Fields{"name": username, "err": err}).Error("error when fetching failed scheduled messages")
+		return
+	}
+	for _, item := range items {
+		note := &MessageNote{Message: "scheduled message with id " + strconv.Itoa(item.ID) + " was not posted: " + item.Failure}
+		mel.IterateOverConnections(username, func(connInfo *ConnInfo) {
+			connInfo.messageStream <- note
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+)
+
+const (
+	// maxUnfurlsPerMessage - only this many first links of a message are unfurled
+	maxUnfurlsPerMessage = 3
+	// maxUnfurlBodySize - only this many first bytes of a page are looked at
+	maxUnfurlBodySize = 512 * 1024
+	// maxEmbedFieldLength - embed fields are truncated to this many runes
+	maxEmbedFieldLength = 512
+	// maxEmbedImageSize - preview images larger than this many bytes are not stored
+	maxEmbedImageSize = 2 * 1024 * 1024
+)
+
+var (
+	urlRegexp       = regexp.MustCompile(`https?://[^\s<>"]+`)
+	metaTagRegexp   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrRegexp      = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	titleTagRegexp  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	errBlockedAddr  = errors.New("address is not allowed")
+	errNotHTML      = errors.New("not an HTML page")
+	errBadURLScheme = errors.New("unsupported URL scheme")
+	errNotImage     = errors.New("not an image")
+	errTooLarge     = errors.New("response is too large")
+	// blockedNets - ranges not covered by net.IP methods which must not be fetched either
+	blockedNets = parseCIDRs(
+		"0.0.0.0/8",     // "this network"
+		"100.64.0.0/10", // carrier-grade NAT
+		"64:ff9b::/96",  // NAT64, which maps IPv4 addresses including private ones
+		"64:ff9b:1::/48",
+	)
+)
+
+// parseCIDRs - parses network ranges, panicking on invalid ones
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets
+}
+
+// Unfurler - fetches previews of links posted in messages
+type Unfurler struct {
+	client *http.Client
+}
+
+// NewUnfurler - creates a new Unfurler
+func NewUnfurler() *Unfurler {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		// checked after DNS resolution so that hostnames pointing to internal addresses are blocked too
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isBlockedIP(ip) {
+				return errBlockedAddr
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Unfurler{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 3 {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return errBadURLScheme
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// isBlockedIP - checks if an address is private, loopback or otherwise not on the public internet
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, ipnet := range blockedNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanForURLs - finds unique links in a message
+func scanForURLs(message string) []string {
+	urls := []string{}
+	for _, match := range urlRegexp.FindAllString(message, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}'")
+		if len(match) > 2048 || contains(urls, match) {
+			continue
+		}
+		urls = append(urls, match)
+		if len(urls) == maxUnfurlsPerMessage {
+			break
+		}
+	}
+	return urls
+}
+
+// fetch - sends a GET request for a link. The response body must be closed by the caller
+func (u *Unfurler) fetch(ctx context.Context, link string, accept string) (*http.Response, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, errBadURLScheme
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Melodious link preview")
+	req.Header.Set("Accept", accept)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New("unexpected status " + resp.Status)
+	}
+	return resp, nil
+}
+
+// Unfurl - fetches a page and extracts its preview. Returns nil if the page has nothing to show
+func (u *Unfurler) Unfurl(link string) (*Embed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := u.fetch(ctx, link, "text/html,application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	mediatype, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediatype != "text/html" && mediatype != "application/xhtml+xml") {
+		return nil, errNotHTML
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUnfurlBodySize))
+	if err != nil {
+		return nil, err
+	}
+	return parseEmbed(link, resp.Request.URL, string(body)), nil
+}
+
+// FetchImage - fetches a preview image. Returns its contents and their type detected from them
+func (u *Unfurler) FetchImage(link string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := u.fetch(ctx, link, "image/*")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxEmbedImageSize+1))
+	if err != nil {
+		return nil, "", err
+	} else if len(data) > maxEmbedImageSize {
+		return nil, "", errTooLarge
+	}
+	// the type is sniffed, so that pages cannot make the server serve anything but images
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", errNotImage
+	}
+	return data, contentType, nil
+}
+
+// storeEmbedImage - fetches a preview image and stores it, so that clients can download it from the server instead of
+// a third-party site. Returns its blob id
+func storeEmbedImage(mel *Melodious, link string) (string, error) {
+	data, contentType, err := mel.Unfurler.FetchImage(link)
+	if err != nil {
+		return "", err
+	}
+	id, err := NewBlobID()
+	if err != nil {
+		return "", err
+	}
+	size, err := mel.Blobs.Put(id, bytes.NewReader(data))
+	if err != nil {
+		mel.Blobs.Delete(id)
+		return "", err
+	}
+	err = mel.Database.AddEmbedImage(id, contentType, size)
+	if err != nil {
+		mel.Blobs.Delete(id)
+		return "", err
+	}
+	return id, nil
+}
+
+// parseEmbed - extracts OpenGraph metadata, falling back to plain HTML tags
+func parseEmbed(link string, base *url.URL, page string) *Embed {
+	meta := map[string]string{}
+	for _, tag := range metaTagRegexp.FindAllString(page, -1) {
+		attrs := map[string]string{}
+		for _, attr := range attrRegexp.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = html.UnescapeString(attrs["content"])
+		}
+	}
+	pick := func(keys ...string) string {
+		for _, key := range keys {
+			if value := strings.TrimSpace(meta[key]); value != "" {
+				return truncateRunes(value, maxEmbedFieldLength)
+			}
+		}
+		return ""
+	}
+	embed := &Embed{
+		URL:         link,
+		Title:       pick("og:title", "twitter:title"),
+		Description: pick("og:description", "twitter:description", "description"),
+		SiteName:    pick("og:site_name"),
+	}
+	if embed.Title == "" {
+		if match := titleTagRegexp.FindStringSubmatch(page); match != nil {
+			embed.Title = truncateRunes(strings.TrimSpace(html.UnescapeString(match[1])), maxEmbedFieldLength)
+		}
+	}
+	if image := pick("og:image", "twitter:image"); image != "" {
+		if ref, err := url.Parse(image); err == nil {
+			abs := base.ResolveReference(ref)
+			if (abs.Scheme == "http" || abs.Scheme == "https") && len(abs.String()) <= 2048 {
+				embed.Image = abs.String()
+			}
+		}
+	}
+	if embed.Title == "" && embed.Description == "" && embed.Image == "" {
+		return nil
+	}
+	return embed
+}
+
+// truncateRunes - cuts a string to given amount of runes
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// unfurlMessage - fetches previews of links in a posted message and notifies subscribers about them. If the message
+// was edited, its previous previews are replaced, and subscribers are notified even if there are none left
+func unfurlMessage(mel *Melodious, channel string, msg *ChatMessage, edited bool) {
+	urls := scanForURLs(msg.Message)
+	if len(urls) == 0 && !edited {
+		return
+	}
+	embeds := []*Embed{}
+	for _, link := range urls {
+		embed, cached, err := mel.Database.GetCachedEmbed(link)
+		if err != nil {
+			log.WithFields(log.Fields{"url": link, "err": err}).Error("error when fetching a cached link preview")
+			continue
+		}
+		if !cached {
+			embed, err = mel.Unfurler.Unfurl(link)
+			if err != nil {
+				log.WithFields(log.Fields{"url": link, "err": err}).Info("cannot fetch a link preview")
+				embed = nil
+			}
+			if embed != nil && embed.Image != "" {
+				embed.Image, err = storeEmbedImage(mel, embed.Image)
+				if err != nil {
+					log.WithFields(log.Fields{"url": link, "err": err}).Info("cannot fetch a link preview image")
+				}
+			}
+			err = mel.Database.CacheEmbed(link, embed)
+			if err != nil {
+				log.WithFields(log.Fields{"url": link, "err": err}).Error("error when caching a link preview")
+			}
+		}
+		if embed != nil {
+			embeds = append(embeds, embed)
+		}
+	}
+	if len(embeds) == 0 && !edited {
+		return
+	}
+	err := mel.Database.SetMessageEmbeds(msg.ID, embeds)
+	if err != nil {
+		log.WithFields(log.Fields{"id": msg.ID, "err": err}).Error("error when attaching link previews to a message")
+		return
+	}
+	event := &MessageMsgEmbeds{ID: msg.ID, Channel: channel, Embeds: embeds}
+	mel.IterateOverSubscribers(channel, func(connInfo *ConnInfo) {
+		connInfo.messageStream <- event
+	})
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"math"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Flag - Describes a flag in the database
@@ -47,12 +51,222 @@ type FlagQueryResult struct {
 
 // ChatMessage - a message received from message history
 type ChatMessage struct {
-	Message   string   `json:"content"`
-	Pings     []string `json:"pings"`
-	ID        int      `json:"id"`
-	Timestamp string   `json:"timestamp"`
-	Author    string   `json:"author"`
-	AuthorID  int      `json:"author_id"`
+	Message string   `json:"content"`
+	Pings   []string `json:"pings"`
+	// MassPing - "everyone" or "here" if the message mentions everyone who can read the channel or those of them who are online
+	MassPing    string        `json:"mass_ping,omitempty"`
+	ID          int           `json:"id"`
+	Timestamp   string        `json:"timestamp"`
+	Author      string        `json:"author"`
+	AuthorID    int           `json:"author_id"`
+	Edited      string        `json:"edited,omitempty"`
+	ExpiresAt   string        `json:"expires_at,omitempty"`
+	ReplyTo     int           `json:"reply_to,omitempty"`
+	ReplyCount  int           `json:"reply_count"`
+	Reactions   []*Reaction   `json:"reactions,omitempty"`
+	Attachments []*Attachment `json:"attachments,omitempty"`
+	Embeds      []*Embed      `json:"embeds,omitempty"`
+	Poll        *Poll         `json:"poll,omitempty"`
+}
+
+// Poll - describes a poll attached to a message
+type Poll struct {
+	Question string        `json:"question"`
+	Options  []*PollOption `json:"options"`
+	Multi    bool          `json:"multi"`
+	Ends     string        `json:"ends"`
+	Closed   bool          `json:"closed"`
+	// MyVotes - indexes of options the requesting user voted for
+	MyVotes []int `json:"my_votes,omitempty"`
+}
+
+// PollOption - describes an option of a poll and amount of votes for it
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// Embed - describes a preview of a link posted in a message
+type Embed struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// InboxEntry - describes a mention of a user stored in their inbox
+type InboxEntry struct {
+	ID        int          `json:"id"`
+	Channel   string       `json:"channel"`
+	Message   *ChatMessage `json:"message"`
+	Acked     bool         `json:"acked"`
+	Timestamp string       `json:"timestamp"`
+}
+
+// NotifyPrefs - describes notification preferences of a user in a channel
+type NotifyPrefs struct {
+	Channel string `json:"channel"`
+	Level   string `json:"level"`
+	Muted   bool   `json:"muted"`
+}
+
+// Ban - describes an active ban of a user
+type Ban struct {
+	Username  string `json:"username"`
+	Issuer    string `json:"issuer,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp string `json:"timestamp"`
+	// Expires - when the ban is lifted; empty for permanent bans
+	Expires string `json:"expires,omitempty"`
+}
+
+// Timeout - describes an active timeout of a user
+type Timeout struct {
+	Username string `json:"username"`
+	// Channel - channel the user is timed out in; empty for server-wide timeouts
+	Channel   string `json:"channel,omitempty"`
+	Issuer    string `json:"issuer,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp string `json:"timestamp"`
+	Expires   string `json:"expires"`
+}
+
+// IPBan - describes an active ban of an IP address range
+type IPBan struct {
+	ID        int    `json:"id"`
+	Range     string `json:"range"`
+	Issuer    string `json:"issuer,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp string `json:"timestamp"`
+	// Expires - when the ban is lifted; empty for permanent bans
+	Expires string `json:"expires,omitempty"`
+}
+
+// AuditEntry - describes a privileged action recorded in the audit log
+type AuditEntry struct {
+	ID      int    `json:"id"`
+	Actor   string `json:"actor"`
+	Action  string `json:"action"`
+	Target  string `json:"target"`
+	Channel string `json:"channel,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// Before, After - state of the target before and after the action; omitted if there is nothing to show
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Timestamp string          `json:"timestamp"`
+}
+
+// AuditFilter - selects audit log entries. Empty fields do not filter anything
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	Channel string
+}
+
+// PurgeFilter - selects messages of a channel to purge. Zero fields do not filter anything
+type PurgeFilter struct {
+	Author string
+	// After, Before - RFC 3339 timestamps limiting when messages were posted
+	After  string
+	Before string
+	// Last - only this many most recent messages of the channel are considered
+	Last    int
+	Pattern *regexp.Regexp
+}
+
+// Highlight - describes a keyword or a regular expression a user wants to be notified about
+type Highlight struct {
+	ID      int    `json:"id"`
+	Pattern string `json:"pattern"`
+	Regex   bool   `json:"regex"`
+}
+
+// Compile - compiles a highlight into a regular expression. Keywords are matched as whole words in any script, ignoring case
+func (h *Highlight) Compile() (*regexp.Regexp, error) {
+	if h.Regex {
+		return regexp.Compile(h.Pattern)
+	}
+	return regexp.Compile(`(?i)(?:^|[^\p{L}\p{N}_])` + regexp.QuoteMeta(h.Pattern) + `(?:$|[^\p{L}\p{N}_])`)
+}
+
+// ScheduledItem - describes a message or a reminder waiting for its delivery time
+type ScheduledItem struct {
+	ID        int    `json:"id"`
+	Kind      string `json:"kind"`
+	Author    string `json:"author"`
+	Channel   string `json:"channel,omitempty"`
+	Content   string `json:"content"`
+	MessageID int    `json:"message_id,omitempty"`
+	Due       string `json:"due"`
+	// Failure - why a scheduled message could not be posted; empty for pending items
+	Failure string `json:"-"`
+}
+
+// ScheduledPost - describes what to do with a scheduled message whose time has come
+type ScheduledPost struct {
+	Pings    []string
+	MassPing string
+	// Failure - why the message is refused; empty if it can be posted
+	Failure string
+	// Delay - in how many seconds the message can be posted if it cannot be posted yet
+	Delay int
+}
+
+// Attachment - describes an uploaded file
+type Attachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// Reaction - aggregated reactions with one emoji on a message
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Me    bool   `json:"me"`
+}
+
+// MessageEdit - a previous revision of an edited message
+type MessageEdit struct {
+	ID        int    `json:"id"`
+	MessageID int    `json:"message_id"`
+	Message   string `json:"content"`
+	Editor    string `json:"editor"`
+	Timestamp string `json:"timestamp"`
+}
+
+// DMConversation - describes a direct message conversation in the database
+type DMConversation struct {
+	ID           int      `json:"id"`
+	Participants []string `json:"participants"`
+}
+
+// SearchFilter - describes filters of a message search. Empty fields are not filtered by
+type SearchFilter struct {
+	Query      string
+	Author     string
+	ChannelIDs []int
+	From       string
+	To         string
+	Mentions   string
+}
+
+// SearchResult - a message found by a message search
+type SearchResult struct {
+	Channel string       `json:"channel"`
+	Message *ChatMessage `json:"message"`
+}
+
+// UnreadSummary - describes unread messages of a user in a channel
+type UnreadSummary struct {
+	ChannelID int    `json:"channel_id"`
+	Channel   string `json:"channel"`
+	LastRead  int    `json:"last_read"`
+	Unread    int    `json:"unread"`
+	Mentions  int    `json:"mentions"`
 }
 
 // User - describes a user in the database
@@ -73,6 +287,49 @@ type Channel struct {
 	ID    int    `json:"id"`
 	Name  string `json:"name"`
 	Topic string `json:"topic"`
+	// Retention - for how many seconds messages are stored in the channel; 0 if the server-wide period is used
+	Retention int `json:"retention,omitempty"`
+	// Slowmode - minimum interval between posts of a user in the channel in seconds; 0 if disabled
+	Slowmode int `json:"slowmode,omitempty"`
+}
+
+// TokenBucket - allows bursts of up to burst events and refills at rate events per second. MUST NOT be used from
+// several goroutines at once
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket - creates a new full TokenBucket. A bucket with non-positive rate allows everything
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Take - takes a token from the bucket. If there is none, returns false and how long to wait until there is one
+func (b *TokenBucket) Take() (bool, time.Duration) {
+	if b.rate <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// retryAfter - converts a wait duration to whole seconds for the retry-after field of fail messages
+func retryAfter(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
 }
 
 // getPings - gets all mentioned/pinged user IDs from a message string.
@@ -92,6 +349,50 @@ func scanForPings(message string) []int {
 	return ids
 }
 
+// scanForGroupPings - gets all mentioned group IDs from a message string.
+func scanForGroupPings(message string) []int {
+	re := regexp.MustCompile(`\<@&([0-9]+)\>`)
+	ids := []int{}
+	for _, submatch := range re.FindAllStringSubmatch(message, -1) {
+		i, err := strconv.ParseInt(submatch[1], 10, 32)
+		if err == nil {
+			ids = append(ids, int(i))
+		}
+	}
+	return ids
+}
+
+// scanForEveryonePing - checks if a message string mentions everyone in a channel.
+func scanForEveryonePing(message string) bool {
+	return regexp.MustCompile(`\B@everyone\b`).MatchString(message)
+}
+
+// scanForHerePing - checks if a message string mentions everyone who is online in a channel.
+func scanForHerePing(message string) bool {
+	return regexp.MustCompile(`\B@here\b`).MatchString(message)
+}
+
+// parseIPRange - parses an IP address or a CIDR range. A single address is turned into a range containing only it
+func parseIPRange(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+// remoteIP - gets the IP address part of a remote address
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
 // contains - check if a slice contains a value
 func contains(s []string, e string) bool {
 	for _, a := range s {